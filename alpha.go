@@ -0,0 +1,234 @@
+package coloringbook
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+
+	"github.com/sfomuseum/go-coloringbook/outline"
+)
+
+// DEFAULT_ALPHA_BACKGROUND is the default colour that transparent images are composited onto before they are outlined.
+const DEFAULT_ALPHA_BACKGROUND string = "#ffffff"
+
+// AlphaOptions defines how images with an alpha channel (for example cut-out PNG product shots) are handled.
+type AlphaOptions struct {
+	// The colour to composite transparent images onto before they are outlined. If nil white is used.
+	Background color.Color
+	// If greater than 0 the edge of the alpha channel is drawn, with this line width in pixels, as the subject's
+	// outer outline. This only applies to raster outlines.
+	EdgeWidth int
+}
+
+// ParseColor parses 'str', a hex colour in the form "#rgb" or "#rrggbb" (the leading "#" is optional) or one of the names
+// "white" or "black", and returns the corresponding (opaque) colour.
+func ParseColor(str string) (color.RGBA, error) {
+
+	str = strings.ToLower(strings.TrimSpace(str))
+
+	switch str {
+	case "white":
+		return color.RGBA{0xff, 0xff, 0xff, 0xff}, nil
+	case "black":
+		return color.RGBA{0x00, 0x00, 0x00, 0xff}, nil
+	}
+
+	hex := strings.TrimPrefix(str, "#")
+
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("Invalid colour '%s'", str)
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("Invalid colour '%s', %w", str, err)
+	}
+
+	c := color.RGBA{
+		R: uint8(v >> 16),
+		G: uint8(v >> 8),
+		B: uint8(v),
+		A: 0xff,
+	}
+
+	return c, nil
+}
+
+// HasAlpha returns a boolean flag indicating whether 'im' contains any pixels which are not fully opaque.
+func HasAlpha(im image.Image) bool {
+
+	if o, ok := im.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+
+	bounds := im.Bounds()
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+
+			_, _, _, a := im.At(x, y).RGBA()
+
+			if a != 0xffff {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// AlphaMask returns a mask for 'im' where pixels that are at least half opaque are white and all other pixels are black.
+func AlphaMask(im image.Image) *image.Gray {
+
+	bounds := im.Bounds()
+	mask := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+
+			_, _, _, a := im.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+
+			if a >= 0x8000 {
+				mask.Pix[y*mask.Stride+x] = 0xff
+			}
+		}
+	}
+
+	return mask
+}
+
+// FlattenAlpha returns a copy of 'im' composited onto a solid 'background' colour.
+func FlattenAlpha(im image.Image, background color.Color) image.Image {
+
+	bounds := im.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), im, bounds.Min, draw.Over)
+
+	return flat
+}
+
+// FlattenObjectImage composites 'im' onto the background colour defined by 'opts' (or white if 'opts' is nil) if it
+// has an alpha channel. It returns the (flattened) image and the mask derived by AlphaMask, or nil if 'im' is opaque.
+func FlattenObjectImage(im image.Image, opts *AlphaOptions) (image.Image, *image.Gray) {
+
+	if !HasAlpha(im) {
+		return im, nil
+	}
+
+	var background color.Color = color.White
+
+	if opts != nil && opts.Background != nil {
+		background = opts.Background
+	}
+
+	return FlattenAlpha(im, background), AlphaMask(im)
+}
+
+// DrawAlphaEdge returns a copy of 'im' with the edge between the white and black pixels in 'mask' drawn on top of it,
+// using black lines 'line_width' pixels wide. 'mask' is scaled to the size of 'im'. Edges along the border of 'mask' are not drawn.
+func DrawAlphaEdge(im image.Image, mask *image.Gray, line_width int) image.Image {
+
+	bounds := im.Bounds()
+
+	w := bounds.Dx()
+	h := bounds.Dy()
+
+	mw := mask.Rect.Dx()
+	mh := mask.Rect.Dy()
+
+	if w == 0 || h == 0 || mw == 0 || mh == 0 || line_width <= 0 {
+		return im
+	}
+
+	is_fg := func(x int, y int) bool {
+		return mask.Pix[y*mask.Stride+x] >= 0x80
+	}
+
+	edges := make([]bool, w*h)
+
+	for my := 0; my < mh; my++ {
+		for mx := 0; mx < mw; mx++ {
+
+			if !is_fg(mx, my) {
+				continue
+			}
+
+			is_edge := (mx > 0 && !is_fg(mx-1, my)) ||
+				(mx < mw-1 && !is_fg(mx+1, my)) ||
+				(my > 0 && !is_fg(mx, my-1)) ||
+				(my < mh-1 && !is_fg(mx, my+1))
+
+			if !is_edge {
+				continue
+			}
+
+			// Scale the mask pixel to the (possibly larger) area it covers in 'im'
+
+			x0 := mx * w / mw
+			x1 := max((mx+1)*w/mw, x0+1)
+			y0 := my * h / mh
+			y1 := max((my+1)*h/mh, y0+1)
+
+			for y := y0; y < y1 && y < h; y++ {
+				for x := x0; x < x1 && x < w; x++ {
+					edges[y*w+x] = true
+				}
+			}
+		}
+	}
+
+	lines := drawEdges(edges, w, h, line_width).(*image.Gray)
+
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(out, out.Bounds(), im, bounds.Min, draw.Src)
+
+	for i, v := range lines.Pix {
+
+		if v != 0 {
+			continue
+		}
+
+		out.Pix[i*4] = 0x00
+		out.Pix[i*4+1] = 0x00
+		out.Pix[i*4+2] = 0x00
+		out.Pix[i*4+3] = 0xff
+	}
+
+	return out
+}
+
+// AddAlphaEdge returns a copy of the raster outline 'o' with the edge of 'mask' drawn as the subject's outer outline, using
+// the line width defined in 'opts'. If 'mask' is nil, no edge width is defined or 'o' is a vector (SVG) outline 'o' is returned unchanged.
+func AddAlphaEdge(ctx context.Context, o outline.Outline, mask *image.Gray, opts *AlphaOptions) (outline.Outline, error) {
+
+	if mask == nil || opts == nil || opts.EdgeWidth <= 0 {
+		return o, nil
+	}
+
+	if _, ok := o.(*svgOutline); ok {
+		return o, nil
+	}
+
+	if _, ok := o.(*outline.SVGOutline); ok {
+		return o, nil
+	}
+
+	im, err := OutlineImage(ctx, o)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &imageOutline{image: DrawAlphaEdge(im, mask, opts.EdgeWidth)}, nil
+}
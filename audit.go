@@ -0,0 +1,264 @@
+package coloringbook
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/tidwall/gjson"
+	"github.com/whosonfirst/go-whosonfirst-iterate/v2/iterator"
+	"github.com/whosonfirst/go-whosonfirst-uri"
+	"gocloud.dev/blob"
+)
+
+type AuditOptions struct {
+	// The bucket where coloring book sheets are published.
+	Bucket *blob.Bucket
+	// A valid whosonfirst/go-whosonfirst-iterate/v2 URI used to iterate object records.
+	IteratorURI string
+	// One or more sources for IteratorURI to iterate.
+	IteratorSources []string
+}
+
+// AuditRecord describes a single object (or published file) that failed an audit check.
+type AuditRecord struct {
+	ObjectId     int64  `json:"object_id"`
+	ImageId      int64  `json:"image_id,omitempty"`
+	PrimaryImage int64  `json:"primary_image,omitempty"`
+	PDF          string `json:"pdf,omitempty"`
+	// The outline metrics which fell outside the acceptable range when the sheet was generated, if any.
+	QualityIssues []string `json:"quality_issues,omitempty"`
+}
+
+// AuditReport is the result of reconciling the contents of a bucket with a set of object records.
+type AuditReport struct {
+	// The number of object records that were audited.
+	Objects int `json:"objects"`
+	// The number of PDF files that were audited.
+	PDFs int `json:"pdfs"`
+	// Objects flagged as having a coloring book with no corresponding PDF file.
+	MissingPDF []*AuditRecord `json:"missing_pdf"`
+	// PDF files for objects which are not flagged as having a coloring book, or for which no object record was found.
+	Unflagged []*AuditRecord `json:"unflagged"`
+	// PDF files whose image ID does not match the object's current "millsfield:primary_image" property. Objects without
+	// a primary image are never considered stale.
+	StaleImage []*AuditRecord `json:"stale_image"`
+	// PDF files with no corresponding thumbnail image.
+	MissingThumbnail []*AuditRecord `json:"missing_thumbnail"`
+	// PDF files whose manifest records outline metrics outside the acceptable range.
+	QualityIssues []*AuditRecord `json:"quality_issues"`
+}
+
+type auditObject struct {
+	has_coloring_book bool
+	primary_image     int64
+}
+
+// Audit reconciles the coloring book sheets published in the bucket defined by 'opts' with the
+// object records emitted by the iterator defined by 'opts'.
+func Audit(ctx context.Context, opts *AuditOptions) (*AuditReport, error) {
+
+	objects := new(sync.Map)
+
+	iter_cb := func(ctx context.Context, path string, r io.ReadSeeker, args ...interface{}) error {
+
+		_, uri_args, err := uri.ParseURI(path)
+
+		if err != nil {
+			return fmt.Errorf("Failed to parse URI for %s, %w", path, err)
+		}
+
+		if uri_args.IsAlternate {
+			return nil
+		}
+
+		body, err := io.ReadAll(r)
+
+		if err != nil {
+			return fmt.Errorf("Failed to read %s, %w", path, err)
+		}
+
+		id_rsp := gjson.GetBytes(body, "properties.wof:id")
+
+		if !id_rsp.Exists() {
+			return fmt.Errorf("%s is missing wof:id property", path)
+		}
+
+		has_rsp := gjson.GetBytes(body, HAS_COLORING_BOOK_PROPERTY)
+		primary_rsp := gjson.GetBytes(body, "properties.millsfield:primary_image")
+
+		o := &auditObject{
+			has_coloring_book: has_rsp.Exists() && has_rsp.Int() == 1,
+			primary_image:     primary_rsp.Int(),
+		}
+
+		objects.Store(id_rsp.Int(), o)
+		return nil
+	}
+
+	iter, err := iterator.NewIterator(ctx, opts.IteratorURI, iter_cb)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create new iterator, %w", err)
+	}
+
+	err = iter.IterateURIs(ctx, opts.IteratorSources...)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to iterate URIs, %w", err)
+	}
+
+	// Index the PDF and thumbnail files in the bucket, by object ID
+
+	pdfs := make(map[int64][]string)
+	thumbs := make(map[string]bool)
+	manifests := make(map[string]bool)
+
+	list_iter := opts.Bucket.List(&blob.ListOptions{})
+
+	for {
+
+		obj, err := list_iter.Next(ctx)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list bucket, %w", err)
+		}
+
+		if obj.IsDir {
+			continue
+		}
+
+		object_id, _, ext, err := ParseFilename(obj.Key)
+
+		if err != nil {
+			continue
+		}
+
+		switch ext {
+		case "pdf":
+			pdfs[object_id] = append(pdfs[object_id], obj.Key)
+		case "png":
+			thumbs[obj.Key] = true
+		case "json":
+			manifests[obj.Key] = true
+		}
+	}
+
+	report := &AuditReport{
+		MissingPDF:       make([]*AuditRecord, 0),
+		Unflagged:        make([]*AuditRecord, 0),
+		StaleImage:       make([]*AuditRecord, 0),
+		MissingThumbnail: make([]*AuditRecord, 0),
+		QualityIssues:    make([]*AuditRecord, 0),
+	}
+
+	objects.Range(func(k interface{}, v interface{}) bool {
+
+		object_id := k.(int64)
+		o := v.(*auditObject)
+
+		report.Objects += 1
+
+		if o.has_coloring_book && len(pdfs[object_id]) == 0 {
+
+			report.MissingPDF = append(report.MissingPDF, &AuditRecord{
+				ObjectId:     object_id,
+				PrimaryImage: o.primary_image,
+			})
+		}
+
+		return true
+	})
+
+	for object_id, keys := range pdfs {
+
+		var o *auditObject
+
+		v, exists := objects.Load(object_id)
+
+		if exists {
+			o = v.(*auditObject)
+		}
+
+		for _, k := range keys {
+
+			report.PDFs += 1
+
+			_, image_id, _, _ := ParseFilename(k)
+
+			r := &AuditRecord{
+				ObjectId: object_id,
+				ImageId:  image_id,
+				PDF:      k,
+			}
+
+			if o == nil || !o.has_coloring_book {
+				report.Unflagged = append(report.Unflagged, r)
+			}
+
+			if o != nil {
+
+				r.PrimaryImage = o.primary_image
+
+				// Objects without a primary image can't be compared (or regenerated)
+
+				if o.primary_image != 0 && o.primary_image != image_id {
+					report.StaleImage = append(report.StaleImage, r)
+				}
+			}
+
+			if !thumbs[ThumbnailFilename(k)] {
+				report.MissingThumbnail = append(report.MissingThumbnail, r)
+			}
+
+			manifest_key := ManifestFilename(k)
+
+			if manifests[manifest_key] {
+
+				m, err := ReadManifest(ctx, opts.Bucket, manifest_key)
+
+				if err != nil {
+					return nil, err
+				}
+
+				if len(m.QualityIssues) > 0 {
+
+					q := *r
+					q.QualityIssues = m.QualityIssues
+
+					report.QualityIssues = append(report.QualityIssues, &q)
+				}
+			}
+		}
+	}
+
+	for _, records := range [][]*AuditRecord{
+		report.MissingPDF,
+		report.Unflagged,
+		report.StaleImage,
+		report.MissingThumbnail,
+		report.QualityIssues,
+	} {
+		sortAuditRecords(records)
+	}
+
+	return report, nil
+}
+
+func sortAuditRecords(records []*AuditRecord) {
+
+	sort.Slice(records, func(i, j int) bool {
+
+		if records[i].ObjectId == records[j].ObjectId {
+			return records[i].PDF < records[j].PDF
+		}
+
+		return records[i].ObjectId < records[j].ObjectId
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/url"
 
 	"github.com/aaronland/go-aws-lambda"
 )
@@ -13,9 +14,22 @@ const GENERATE_COLORING_BOOK_LAMBDA_URI string = "aws://GenerateColouringBook?re
 type ColoringBookRequest struct {
 	ObjectId     int64 `json:"object_id"`
 	UpdateObject bool  `json:"update_object"`
+	// The name of an optional outline preset to use instead of the defaults.
+	Preset string `json:"preset,omitempty"`
+	// An optional list of difficulty variants (preset names) to publish instead of the defaults.
+	Variants []string `json:"variants,omitempty"`
 	// ContourIterations int `json:"contour_iterations"`
 }
 
+// ColoringBookResult is the outcome of processing a single ColoringBookRequest
+// outside of Lambda, for example when replaying requests locally.
+type ColoringBookResult struct {
+	Line     int    `json:"line,omitempty"`
+	ObjectId int64  `json:"object_id"`
+	Ok       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+}
+
 func GenerateColoringBookLambda(ctx context.Context, function_uri string, object_id int64) error {
 
 	f, err := lambda.NewLambdaFunction(ctx, function_uri)
@@ -42,3 +56,50 @@ func GenerateColoringBookLambda(ctx context.Context, function_uri string, object
 
 	return nil
 }
+
+// GenerateColoringBookLambdaAndWait invokes the Lambda function defined by 'function_uri' to generate the coloring book
+// sheet for 'object_id' synchronously ("RequestResponse"), regardless of the invocation type defined by 'function_uri',
+// and returns an error if the function fails.
+func GenerateColoringBookLambdaAndWait(ctx context.Context, function_uri string, object_id int64) error {
+
+	u, err := url.Parse(function_uri)
+
+	if err != nil {
+		return fmt.Errorf("Failed to parse function URI, %w", err)
+	}
+
+	q := u.Query()
+	q.Set("type", "RequestResponse")
+
+	u.RawQuery = q.Encode()
+
+	f, err := lambda.NewLambdaFunction(ctx, u.String())
+
+	if err != nil {
+		return fmt.Errorf("Failed to create new Lambda function, %w", err)
+	}
+
+	req := ColoringBookRequest{
+		ObjectId: object_id,
+	}
+
+	payload, err := json.Marshal(req)
+
+	if err != nil {
+		return fmt.Errorf("Failed to marshal request, %w", err)
+	}
+
+	rsp, err := f.InvokeWithJSON(ctx, payload)
+
+	if err != nil {
+		return fmt.Errorf("Failed to invoke function, %w", err)
+	}
+
+	// Errors returned by the function itself are reported with a 200 status code
+
+	if rsp.FunctionError != nil {
+		return fmt.Errorf("Function failed (%s), %s", *rsp.FunctionError, string(rsp.Payload))
+	}
+
+	return nil
+}
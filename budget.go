@@ -0,0 +1,78 @@
+package coloringbook
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/nfnt/resize"
+)
+
+// DEFAULT_MAX_PIXELS is the default maximum number of pixels (width x height) in an image that will be decoded.
+const DEFAULT_MAX_PIXELS int = 50_000_000
+
+// DecodeImageOptions defines configuration options for the DecodeImageWithOptions method.
+type DecodeImageOptions struct {
+	// The maximum number of pixels (width x height) in an image. Larger images are refused before they are decoded. If 0 there is no limit.
+	MaxPixels int
+}
+
+// CheckPixelBudget reads the dimensions of the image in 'body' from its header, without decoding the image, and returns
+// an error if it contains more than 'max_pixels' pixels. If 'max_pixels' is 0 only the header is checked.
+func CheckPixelBudget(body []byte, max_pixels int) (image.Config, string, error) {
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(body))
+
+	if err != nil && isCMYKTIFF(body) {
+		cfg, err = decodeCMYKTIFFConfig(body)
+		format = "tiff"
+	}
+
+	if err != nil {
+		return cfg, format, fmt.Errorf("Failed to decode image header, %w", err)
+	}
+
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return cfg, format, fmt.Errorf("Invalid image dimensions (%dx%d)", cfg.Width, cfg.Height)
+	}
+
+	pixels := int64(cfg.Width) * int64(cfg.Height)
+
+	if max_pixels > 0 && pixels > int64(max_pixels) {
+		return cfg, format, fmt.Errorf("Image dimensions (%dx%d, %d pixels) exceed the maximum of %d pixels", cfg.Width, cfg.Height, pixels, max_pixels)
+	}
+
+	return cfg, format, nil
+}
+
+// TraceSize returns the maximum width and height, in pixels, of a source image 'im' that is useful to outline given that
+// outline images are scaled by 'contour_scale' and laid out by AddSheet at SHEET_DPI within the printable area of the page.
+func TraceSize(im image.Image, contour_scale float64) (int, int) {
+
+	if contour_scale <= 0 {
+		contour_scale = 1.0
+	}
+
+	max_w, max_h := PrintableArea(Orientation(im))
+
+	w := int(math.Ceil(max_w * SHEET_DPI / contour_scale))
+	h := int(math.Ceil(max_h * SHEET_DPI / contour_scale))
+
+	return w, h
+}
+
+// DownscaleForTrace returns a copy of 'im' resized, preserving its aspect ratio, so that it is no larger than the
+// dimensions returned by TraceSize. If 'im' is already small enough it is returned unchanged.
+func DownscaleForTrace(im image.Image, contour_scale float64) image.Image {
+
+	max_w, max_h := TraceSize(im, contour_scale)
+
+	bounds := im.Bounds()
+
+	if bounds.Dx() <= max_w && bounds.Dy() <= max_h {
+		return im
+	}
+
+	return resize.Thumbnail(uint(max_w), uint(max_h), im, resize.Lanczos3)
+}
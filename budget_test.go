@@ -0,0 +1,56 @@
+package coloringbook
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestCheckPixelBudget(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 100, 50)))
+
+	if err != nil {
+		t.Fatalf("Failed to encode test image, %v", err)
+	}
+
+	body := buf.Bytes()
+
+	tests := []struct {
+		body       []byte
+		max_pixels int
+		ok         bool
+	}{
+		{body, 0, true},
+		{body, 5000, true},
+		{body, 4999, false},
+		{body, 1_000_000, true},
+		{[]byte("not an image"), 0, false},
+	}
+
+	for i, test := range tests {
+
+		cfg, format, err := CheckPixelBudget(test.body, test.max_pixels)
+
+		if !test.ok {
+
+			if err == nil {
+				t.Errorf("Expected test %d to fail", i)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Failed to check test %d, %v", i, err)
+			continue
+		}
+
+		if cfg.Width != 100 || cfg.Height != 50 || format != "png" {
+			t.Errorf("Unexpected config for test %d, %+v %s", i, cfg, format)
+		}
+	}
+}
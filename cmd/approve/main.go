@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	_ "github.com/aaronland/gocloud-blob-s3"
+	aa_bucket "github.com/aaronland/gocloud-blob/bucket"
+	"github.com/sfomuseum/go-flags/flagset"
+	"github.com/sfomuseum/go-sfomuseum-coloringbook"
+	"github.com/whosonfirst/go-reader"
+	_ "github.com/whosonfirst/go-reader-http"
+	wof_reader "github.com/whosonfirst/go-whosonfirst-reader"
+	_ "gocloud.dev/blob/fileblob"
+)
+
+func main() {
+
+	var reader_uri string
+	var writer_uri string
+	var staging_bucket_uri string
+	var bucket_uri string
+	var update_object bool
+	var append_tree bool
+	var access_token_uri string
+	var public_root_uri string
+
+	var reject bool
+	var list bool
+
+	var publish_acl string
+	var publish_cache_control string
+	var publish_content_disposition string
+	var publish_pdf_content_type string
+	var publish_thumbnail_content_type string
+	var publish_include_ids bool
+	var publish_metadata string
+
+	fs := flagset.NewFlagSet("coloringbook")
+
+	fs.StringVar(&reader_uri, "reader-uri", "https://static.sfomuseum.org/data/", "A valid whosonfirst/go-reader URI used to read object records.")
+	fs.StringVar(&writer_uri, "writer-uri", "stdout://", "A valid whosonfirst/go-writer URI used to write updated object records.")
+	fs.StringVar(&staging_bucket_uri, "staging-bucket-uri", "", "A valid gocloud.dev/blob URI for the bucket where sheets awaiting review have been written (using cmd/pdf -stage).")
+	fs.StringVar(&bucket_uri, "bucket-uri", "", "A valid gocloud.dev/blob URI for the bucket where approved sheets are published.")
+	fs.BoolVar(&update_object, "update-object", false, "Update the object record for approved sheets.")
+	fs.BoolVar(&append_tree, "append-tree", false, "Sheets are stored using a Who's On First -style tree.")
+	fs.StringVar(&access_token_uri, "access-token-uri", "", "An optional gocloud.dev/runtimevar URI referencing a GitHub access token to use with -writer-uri.")
+	fs.StringVar(&public_root_uri, "public-root-uri", "", "An optional URI to prepend to the filenames of published files when recording them in the \"millsfield:coloring_book\" property of an object record.")
+
+	fs.BoolVar(&reject, "reject", false, "Reject, rather than approve, the sheets awaiting review.")
+	fs.BoolVar(&list, "list", false, "Emit a JSON-encoded list of all the sheets awaiting review to STDOUT and exit.")
+
+	fs.StringVar(&publish_acl, "publish-acl", "public-read", "The AWS S3 canned ACL to assign to published files. This is ignored by non-S3 buckets. If empty no ACL is assigned.")
+	fs.StringVar(&publish_cache_control, "publish-cache-control", "", "An optional Cache-Control header to assign to published files.")
+	fs.StringVar(&publish_content_disposition, "publish-content-disposition", "", "An optional Content-Disposition header to assign to published files. The string \"{filename}\" will be replaced by the base name of each published file.")
+	fs.StringVar(&publish_pdf_content_type, "publish-pdf-content-type", "application/pdf", "The Content-Type header to assign to published PDF files.")
+	fs.StringVar(&publish_thumbnail_content_type, "publish-thumbnail-content-type", "image/png", "The Content-Type header to assign to published thumbnail images.")
+	fs.BoolVar(&publish_include_ids, "publish-include-ids", true, "Assign the object and image IDs as custom metadata (\"object-id\" and \"image-id\") to published files.")
+	fs.StringVar(&publish_metadata, "publish-metadata", "", "An optional comma-separated list of key=value pairs to assign as custom metadata to published files.")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Approve (or reject) coloring book sheets awaiting review, copying approved sheets to the public bucket and updating their object records.\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n\t %s [options] object_id(N) object_id(N)\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	flagset.Parse(fs)
+
+	err := flagset.SetFlagsFromEnvVars(fs, "SFOMUSEUM")
+
+	if err != nil {
+		log.Fatalf("Failed to set flags from environment variables, %v", err)
+	}
+
+	ctx := context.Background()
+
+	if staging_bucket_uri == "" {
+		log.Fatalf("Missing -staging-bucket-uri flag")
+	}
+
+	staging_bucket, err := aa_bucket.OpenBucket(ctx, staging_bucket_uri)
+
+	if err != nil {
+		log.Fatalf("Failed to open staging bucket, %v", err)
+	}
+
+	defer staging_bucket.Close()
+
+	if list {
+
+		pending, err := coloringbook.ListPending(ctx, staging_bucket)
+
+		if err != nil {
+			log.Fatalf("Failed to list sheets awaiting review, %v", err)
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+
+		err = enc.Encode(pending)
+
+		if err != nil {
+			log.Fatalf("Failed to encode sheets awaiting review, %v", err)
+		}
+
+		return
+	}
+
+	custom_metadata, err := coloringbook.ParseMetadata(publish_metadata)
+
+	if err != nil {
+		log.Fatalf("Failed to parse -publish-metadata flag, %v", err)
+	}
+
+	publish_opts := &coloringbook.PublishOptions{
+		ACL:                  publish_acl,
+		CacheControl:         publish_cache_control,
+		ContentDisposition:   publish_content_disposition,
+		PDFContentType:       publish_pdf_content_type,
+		ThumbnailContentType: publish_thumbnail_content_type,
+		IncludeIds:           publish_include_ids,
+		Metadata:             custom_metadata,
+	}
+
+	review_opts := &coloringbook.ReviewOptions{
+		StagingBucket:  staging_bucket,
+		PublishOptions: publish_opts,
+		AppendTree:     append_tree,
+	}
+
+	var r reader.Reader
+
+	if !reject {
+
+		if bucket_uri == "" {
+			log.Fatalf("Missing -bucket-uri flag")
+		}
+
+		bucket, err := aa_bucket.OpenBucket(ctx, bucket_uri)
+
+		if err != nil {
+			log.Fatalf("Failed to open bucket, %v", err)
+		}
+
+		defer bucket.Close()
+
+		review_opts.Bucket = bucket
+
+		if update_object {
+
+			r, err = reader.NewReader(ctx, reader_uri)
+
+			if err != nil {
+				log.Fatalf("Failed to create reader, %v", err)
+			}
+		}
+	}
+
+	for _, str_id := range fs.Args() {
+
+		object_id, err := strconv.ParseInt(str_id, 10, 64)
+
+		if err != nil {
+			log.Fatalf("Invalid object ID '%s', %v", str_id, err)
+		}
+
+		if reject {
+
+			_, err := coloringbook.Reject(ctx, review_opts, object_id)
+
+			if err != nil {
+				log.Fatalf("Failed to reject sheets for object %d, %v", object_id, err)
+			}
+
+			log.Printf("Rejected sheets for object %d\n", object_id)
+			continue
+		}
+
+		approved, err := coloringbook.Approve(ctx, review_opts, object_id)
+
+		if err != nil {
+			log.Fatalf("Failed to approve sheets for object %d, %v", object_id, err)
+		}
+
+		log.Printf("Approved sheets for object %d\n", object_id)
+
+		if !update_object {
+			continue
+		}
+
+		// Record the most recently generated sheet (and any difficulty variants generated alongside it)
+
+		latest := coloringbook.LatestManifests(approved)
+
+		body, err := wof_reader.LoadBytes(ctx, r, object_id)
+
+		if err != nil {
+			log.Fatalf("Failed to load record for object %d, %v", object_id, err)
+		}
+
+		has_updates, new_body, err := coloringbook.AssignColoringBookProperties(ctx, body, coloringbook.ManifestsColoringBookProperty(latest, public_root_uri))
+
+		if err != nil {
+			log.Fatalf("Failed to assign coloring book properties for object %d, %v", object_id, err)
+		}
+
+		if !has_updates {
+			continue
+		}
+
+		err = coloringbook.WriteObjectRecord(ctx, writer_uri, access_token_uri, new_body)
+
+		if err != nil {
+			log.Fatalf("Failed to update record for object %d, %v", object_id, err)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	aa_bucket "github.com/aaronland/gocloud-blob/bucket"
+	"github.com/sfomuseum/go-flags/flagset"
+	"github.com/sfomuseum/go-sfomuseum-coloringbook"
+	"gocloud.dev/blob"
+	_ "gocloud.dev/blob/fileblob"
+)
+
+func main() {
+
+	var iterator_uri string
+	var bucket_uri string
+	var function_uri string
+	var fix bool
+	var append_tree bool
+
+	fs := flagset.NewFlagSet("coloringbook")
+
+	fs.StringVar(&iterator_uri, "iterator-uri", "repo://", "A valid whosonfirst/go-whosonfirst-iterate/v2 URI used to iterate object records.")
+	fs.StringVar(&bucket_uri, "bucket-uri", "cwd://", "A valid gocloud.dev/blob URI for the bucket where coloring book sheets are published.")
+	fs.BoolVar(&fix, "fix", false, "Regenerate the coloring book sheets for objects with missing PDF files, missing thumbnails or stale images. Sheets for objects with stale images are regenerated synchronously and the files derived from stale images are only removed once the sheet for the current primary image exists. Objects without a primary image are skipped and PDF files for unflagged objects are reported but not changed.")
+	fs.BoolVar(&append_tree, "append-tree", false, "Sheets were published using a Who's On First -style tree. This is used to find the files to remove for stale images when -fix is enabled.")
+	fs.StringVar(&function_uri, "function-uri", coloringbook.GENERATE_COLORING_BOOK_LAMBDA_URI, "The URI of the Lambda function used to regenerate coloring book sheets when -fix is enabled.")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Reconcile the coloring book sheets published in a bucket with object records and emit a JSON-encoded report to STDOUT.\n")
+		fmt.Fprintf(os.Stderr, "If -fix is enabled sheets with missing files or stale images are regenerated. Unflagged PDF files are report-only and need to be flagged (or unpublished) manually.\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n\t %s [options] source(N) source(N)\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	flagset.Parse(fs)
+
+	err := flagset.SetFlagsFromEnvVars(fs, "SFOMUSEUM")
+
+	if err != nil {
+		log.Fatalf("Failed to set flags from environment variables, %v", err)
+	}
+
+	ctx := context.Background()
+
+	if bucket_uri == "cwd://" {
+
+		cwd, err := os.Getwd()
+
+		if err != nil {
+			log.Fatalf("Failed to derive current working directory, %v", err)
+		}
+
+		bucket_uri = fmt.Sprintf("file://%s", cwd)
+	}
+
+	bucket, err := aa_bucket.OpenBucket(ctx, bucket_uri)
+
+	if err != nil {
+		log.Fatalf("Failed to open bucket, %v", err)
+	}
+
+	defer bucket.Close()
+
+	audit_opts := &coloringbook.AuditOptions{
+		Bucket:          bucket,
+		IteratorURI:     iterator_uri,
+		IteratorSources: fs.Args(),
+	}
+
+	report, err := coloringbook.Audit(ctx, audit_opts)
+
+	if err != nil {
+		log.Fatalf("Failed to audit bucket, %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	err = enc.Encode(report)
+
+	if err != nil {
+		log.Fatalf("Failed to encode report, %v", err)
+	}
+
+	if !fix {
+		return
+	}
+
+	// Objects whose sheets are regenerated and, for objects with stale images, the stale image IDs to remove
+
+	regenerate := make([]*coloringbook.AuditRecord, 0)
+	stale := make(map[int64]map[int64]bool)
+
+	seen := make(map[int64]bool)
+
+	for _, records := range [][]*coloringbook.AuditRecord{
+		report.MissingPDF,
+		report.StaleImage,
+		report.MissingThumbnail,
+	} {
+
+		for _, r := range records {
+
+			if r.PrimaryImage == 0 {
+				log.Printf("Object %d has no primary image, skipping\n", r.ObjectId)
+				continue
+			}
+
+			if !seen[r.ObjectId] {
+				seen[r.ObjectId] = true
+				regenerate = append(regenerate, r)
+			}
+		}
+	}
+
+	for _, r := range report.StaleImage {
+
+		if r.PrimaryImage == 0 {
+			continue
+		}
+
+		if stale[r.ObjectId] == nil {
+			stale[r.ObjectId] = make(map[int64]bool)
+		}
+
+		stale[r.ObjectId][r.ImageId] = true
+	}
+
+	for _, r := range regenerate {
+
+		log.Printf("Regenerate coloring book sheet for %d\n", r.ObjectId)
+
+		stale_images, is_stale := stale[r.ObjectId]
+
+		if !is_stale {
+
+			err := coloringbook.GenerateColoringBookLambda(ctx, function_uri, r.ObjectId)
+
+			if err != nil {
+				log.Fatalf("Failed to invoke Lambda function for %d, %v", r.ObjectId, err)
+			}
+
+			continue
+		}
+
+		// Files derived from stale images are only removed once the sheet for the current primary image
+		// has been regenerated, so wait for the function to complete and check that the new sheet exists.
+
+		err := coloringbook.GenerateColoringBookLambdaAndWait(ctx, function_uri, r.ObjectId)
+
+		if err != nil {
+			log.Printf("Failed to regenerate coloring book sheet for %d, not removing stale files, %v\n", r.ObjectId, err)
+			continue
+		}
+
+		ok, err := hasSheet(ctx, bucket, r.ObjectId, r.PrimaryImage, append_tree)
+
+		if err != nil {
+			log.Fatalf("Failed to find coloring book sheet for %d, %v", r.ObjectId, err)
+		}
+
+		if !ok {
+			log.Printf("No coloring book sheet for object %d (image %d) found after regenerating it, not removing stale files\n", r.ObjectId, r.PrimaryImage)
+			continue
+		}
+
+		// Difficulty variants derived from the same image are removed together
+
+		for image_id, _ := range stale_images {
+
+			unpublish_opts := &coloringbook.UnpublishOptions{
+				Bucket:     bucket,
+				AppendTree: append_tree,
+				ImageId:    image_id,
+			}
+
+			_, err := coloringbook.Unpublish(ctx, unpublish_opts, r.ObjectId)
+
+			if err != nil {
+				log.Fatalf("Failed to remove stale files for object %d (image %d), %v", r.ObjectId, image_id, err)
+			}
+		}
+	}
+}
+
+// hasSheet returns a boolean value indicating whether a PDF sheet derived from 'image_id' has been published for 'object_id' in 'bucket'.
+func hasSheet(ctx context.Context, bucket *blob.Bucket, object_id int64, image_id int64, append_tree bool) (bool, error) {
+
+	keys, err := coloringbook.ListFiles(ctx, bucket, object_id, append_tree)
+
+	if err != nil {
+		return false, err
+	}
+
+	for _, k := range keys {
+
+		if !strings.HasSuffix(k, ".pdf") {
+			continue
+		}
+
+		_, k_id, _, err := coloringbook.ParseFilename(k)
+
+		if err == nil && k_id == image_id {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
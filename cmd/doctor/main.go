@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	_ "github.com/aaronland/gocloud-blob-s3"
+	aa_bucket "github.com/aaronland/gocloud-blob/bucket"
+	"github.com/go-pdf/fpdf"
+	"github.com/sfomuseum/go-coloringbook/outline"
+	"github.com/sfomuseum/go-flags/flagset"
+	"github.com/sfomuseum/go-sfomuseum-coloringbook"
+	"github.com/whosonfirst/go-reader"
+	_ "github.com/whosonfirst/go-reader-http"
+	wof_reader "github.com/whosonfirst/go-whosonfirst-reader"
+	_ "gocloud.dev/blob/fileblob"
+)
+
+//go:embed test.png
+var test_png []byte
+
+const (
+	STATUS_OK      string = "ok"
+	STATUS_FAIL    string = "FAIL"
+	STATUS_SKIPPED string = "skipped"
+)
+
+type check struct {
+	Name   string
+	Status string
+	Detail string
+}
+
+// A tiny SVG document used to test that Batik can rasterize images.
+const test_svg string = `<svg xmlns="http://www.w3.org/2000/svg" width="32" height="32"><rect x="8" y="8" width="16" height="16" fill="#000"/></svg>`
+
+func main() {
+
+	var reader_uri string
+	var bucket_uri string
+	var object_id int64
+	var timeout time.Duration
+
+	fs := flagset.NewFlagSet("coloringbook")
+
+	outline_flags := coloringbook.AppendOutlineFlags(fs)
+
+	fs.StringVar(&reader_uri, "reader-uri", "https://static.sfomuseum.org/data/", "A valid whosonfirst/go-reader URI to check.")
+	fs.StringVar(&bucket_uri, "bucket-uri", "cwd://", "A valid gocloud.dev/blob URI to check.")
+	fs.Int64Var(&object_id, "object-id", 0, "An optional object ID to read using -reader-uri. If 0 the reader is created but not read from.")
+	fs.DurationVar(&timeout, "timeout", 2*time.Minute, "The maximum amount of time to wait for all the checks to complete.")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Check that the external tools, readers and buckets needed to generate coloring book sheets are available and working.\n")
+		fmt.Fprintf(os.Stderr, "Exits with a non-zero status if any check fails.\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n\t %s [options]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	flagset.Parse(fs)
+
+	err := flagset.SetFlagsFromEnvVars(fs, "SFOMUSEUM")
+
+	if err != nil {
+		log.Fatalf("Failed to set flags from environment variables, %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	report := make([]*check, 0)
+
+	add := func(name string, status string, detail string, args ...interface{}) {
+
+		c := &check{
+			Name:   name,
+			Status: status,
+			Detail: fmt.Sprintf(detail, args...),
+		}
+
+		report = append(report, c)
+	}
+
+	// External tools (only required by the vtracer outliner)
+
+	outliner_u, err := url.Parse(outline_flags.OutlinerURI)
+
+	if err != nil {
+		log.Fatalf("Failed to parse -outliner-uri flag, %v", err)
+	}
+
+	uses_vtracer := outliner_u.Scheme == "vtracer"
+	uses_batik := uses_vtracer && outline_flags.UseBatik
+
+	if !uses_vtracer {
+		add("vtracer", STATUS_SKIPPED, "Not used by %s outliner", outliner_u.Scheme)
+	} else if path, err := coloringbook.FindVtracer(); err != nil {
+		add("vtracer", STATUS_FAIL, "%v", err)
+	} else if version, err := coloringbook.VtracerVersion(ctx); err != nil {
+		add("vtracer", STATUS_FAIL, "%s, %v", path, err)
+	} else {
+		add("vtracer", STATUS_OK, "%s (%s)", path, version)
+	}
+
+	if !uses_batik {
+		add("java", STATUS_SKIPPED, "Batik is not used")
+		add("batik", STATUS_SKIPPED, "Batik is not used")
+	} else {
+
+		if path, err := coloringbook.FindJava(); err != nil {
+			add("java", STATUS_FAIL, "%v", err)
+		} else if version, err := coloringbook.JavaVersion(ctx); err != nil {
+			add("java", STATUS_FAIL, "%s, %v", path, err)
+		} else {
+			add("java", STATUS_OK, "%s (%s)", path, version)
+		}
+
+		err := checkBatik(ctx, outline_flags.PathBatik)
+
+		if err != nil {
+			add("batik", STATUS_FAIL, "%v", err)
+		} else {
+			add("batik", STATUS_OK, "%s rasterized test image", outline_flags.PathBatik)
+		}
+	}
+
+	// Reader and bucket
+
+	r, err := reader.NewReader(ctx, reader_uri)
+
+	if err != nil {
+		add("reader", STATUS_FAIL, "Failed to create reader, %v", err)
+	} else if object_id == 0 {
+		add("reader", STATUS_OK, "Created %s (set -object-id to test reading a record)", reader_uri)
+	} else if body, err := wof_reader.LoadBytes(ctx, r, object_id); err != nil {
+		add("reader", STATUS_FAIL, "Failed to read object %d from %s, %v", object_id, reader_uri, err)
+	} else {
+		add("reader", STATUS_OK, "Read object %d (%d bytes) from %s", object_id, len(body), reader_uri)
+	}
+
+	bucket, err := aa_bucket.OpenBucket(ctx, bucket_uri)
+
+	if err != nil {
+		add("bucket", STATUS_FAIL, "Failed to open bucket, %v", err)
+	} else {
+
+		defer bucket.Close()
+
+		ok, err := bucket.IsAccessible(ctx)
+
+		if err != nil {
+			add("bucket", STATUS_FAIL, "Failed to determine whether %s is accessible, %v", bucket_uri, err)
+		} else if !ok {
+			add("bucket", STATUS_FAIL, "%s is not accessible", bucket_uri)
+		} else {
+			add("bucket", STATUS_OK, "%s is accessible", bucket_uri)
+		}
+	}
+
+	// Outline and PDF round trip
+
+	outliner, err := outline_flags.Outliner(ctx)
+
+	if err != nil {
+		add("outline", STATUS_FAIL, "Failed to create outliner, %v", err)
+		add("pdf", STATUS_SKIPPED, "No outline image")
+	} else {
+
+		outline_im, backend, err := checkOutline(ctx, outliner, outline_flags)
+
+		if err != nil {
+			add("outline", STATUS_FAIL, "%v", err)
+			add("pdf", STATUS_SKIPPED, "No outline image")
+		} else {
+
+			add("outline", STATUS_OK, "Outlined test image using %s backend", backend)
+
+			size, err := checkPDF(ctx, outline_im)
+
+			if err != nil {
+				add("pdf", STATUS_FAIL, "%v", err)
+			} else {
+				add("pdf", STATUS_OK, "Generated %d byte PDF file", size)
+			}
+		}
+	}
+
+	// Report
+
+	failed := 0
+
+	for _, c := range report {
+
+		fmt.Printf("%-8s %-8s %s\n", c.Name, c.Status, c.Detail)
+
+		if c.Status == STATUS_FAIL {
+			failed += 1
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(report))
+		os.Exit(1)
+	}
+}
+
+// checkBatik rasterizes a tiny SVG document using the Batik rasterizer JAR file 'path_batik'.
+func checkBatik(ctx context.Context, path_batik string) error {
+
+	err := coloringbook.FindBatik(path_batik)
+
+	if err != nil {
+		return err
+	}
+
+	svg_fh, err := os.CreateTemp("", "doctor.*.svg")
+
+	if err != nil {
+		return fmt.Errorf("Failed to create test SVG file, %w", err)
+	}
+
+	svg_path := svg_fh.Name()
+	defer os.Remove(svg_path)
+
+	_, err = svg_fh.Write([]byte(test_svg))
+
+	if err != nil {
+		return fmt.Errorf("Failed to write test SVG file, %w", err)
+	}
+
+	err = svg_fh.Close()
+
+	if err != nil {
+		return fmt.Errorf("Failed to close test SVG file, %w", err)
+	}
+
+	raster_opts := &outline.RasterizeOptions{
+		UseBatik:  true,
+		PathBatik: path_batik,
+	}
+
+	_, err = outline.RasterizeBatik(ctx, raster_opts, svg_path)
+
+	if err != nil {
+		return fmt.Errorf("Failed to rasterize test image, %w", err)
+	}
+
+	return nil
+}
+
+// checkOutline derives an outline from the embedded test image. It returns the outline image and the name of the
+// backend used to derive it.
+func checkOutline(ctx context.Context, outliner coloringbook.Outliner, outline_flags *coloringbook.OutlineFlags) (image.Image, string, error) {
+
+	presets, err := outline_flags.Presets()
+
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to load presets, %w", err)
+	}
+
+	outline_opts, err := presets.Apply(outline_flags.OutlineOptions(), outline_flags.Preset)
+
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to derive outline options, %w", err)
+	}
+
+	// AddSheet expects PNG images
+	outline_opts.Contour.Format = "png"
+
+	im, _, err := image.Decode(bytes.NewReader(test_png))
+
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to decode test image, %w", err)
+	}
+
+	o, err := outliner.Outline(ctx, im, outline_opts)
+
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to outline test image, %w", err)
+	}
+
+	var buf bytes.Buffer
+
+	err = o.Write(ctx, &buf)
+
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to write outline image, %w", err)
+	}
+
+	outline_im, err := png.Decode(&buf)
+
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to decode outline image, %w", err)
+	}
+
+	return outline_im, outliner.Backend(outline_opts), nil
+}
+
+// checkPDF adds 'im' to a new PDF document and returns the size of the resulting PDF file.
+func checkPDF(ctx context.Context, im image.Image) (int, error) {
+
+	pdf := fpdf.New(coloringbook.Orientation(im), "in", coloringbook.PAGE_SIZE, "")
+
+	sheet_opts := &coloringbook.AddSheetOptions{
+		Image:           im,
+		ImageName:       "doctor.png",
+		URL:             "https://collection.sfomuseum.org/",
+		Title:           "Doctor",
+		Date:            time.Now().Format("2006"),
+		CreditLine:      "Test image",
+		AccessionNumber: "0000.00.000",
+	}
+
+	err := coloringbook.AddSheet(ctx, pdf, sheet_opts)
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to add sheet, %w", err)
+	}
+
+	var pdf_buf bytes.Buffer
+
+	err = pdf.Output(&pdf_buf)
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to write PDF file, %w", err)
+	}
+
+	return pdf_buf.Len(), nil
+}
@@ -2,70 +2,61 @@ package main
 
 import (
 	"context"
-	"image"
 	_ "image/jpeg"
-	_ "image/png"
+	"image/png"
 	"log"
 	"os"
 
 	"github.com/sfomuseum/go-flags/flagset"
-	"github.com/sfomuseum/go-coloringbook/outline"
+	"github.com/sfomuseum/go-sfomuseum-coloringbook"
 )
 
 func main() {
 
-	var contour_iterations int
-	var contour_scale float64
-	var contour_format string
-
-	var vtracer_precision int
-	var vtracer_speckle int
-
-	var use_batik bool
-	var path_batik string
-
 	var infile string
 	var outfile string
+	var mask_outfile string
 
 	fs := flagset.NewFlagSet("coloringbook")
 
-	fs.IntVar(&contour_iterations, "contour-iteration", 8, "...")
-	fs.Float64Var(&contour_scale, "contour-scale", 1.0, "...")
-	fs.StringVar(&contour_format, "contour-format", "png", "...")
-
-	fs.IntVar(&vtracer_precision, "vtracer-precision", 6, "...")
-	fs.IntVar(&vtracer_speckle, "vtracer-speckle", 8, "...")
-
-	fs.BoolVar(&use_batik, "use-batik", true, "...")
-	fs.StringVar(&path_batik, "path-batik", "/usr/local/src/batik-1.17/batik-rasterizer-1.17.jar", "...")
+	outline_flags := coloringbook.AppendOutlineFlags(fs)
 
 	fs.StringVar(&infile, "infile", "", "...")
 	fs.StringVar(&outfile, "outfile", "", "...")
+	fs.StringVar(&mask_outfile, "mask", "", "An optional path to write the foreground mask (white) used to isolate the subject of the image to, for debugging. If -preprocess does not contain an \"isolate\" step the mask is derived using the default tolerance.")
 
 	flagset.Parse(fs)
 
 	ctx := context.Background()
 
-	contour_opts := &outline.ContourOptions{
-		Iterations: contour_iterations,
-		Scale:      contour_scale,
-		Format:     contour_format,
+	outliner, err := outline_flags.Outliner(ctx)
+
+	if err != nil {
+		log.Fatalf("Failed to create outliner, %v", err)
+	}
+
+	preprocess, err := outline_flags.PreprocessSteps()
+
+	if err != nil {
+		log.Fatalf("Failed to parse -preprocess flag, %v", err)
 	}
 
-	trace_opts := &outline.TraceOptions{
-		Precision: vtracer_precision,
-		Speckle:   vtracer_speckle,
+	alpha_opts, err := outline_flags.AlphaOptions()
+
+	if err != nil {
+		log.Fatalf("Failed to derive alpha options, %v", err)
 	}
 
-	raster_opts := &outline.RasterizeOptions{
-		UseBatik:  use_batik,
-		PathBatik: path_batik,
+	presets, err := outline_flags.Presets()
+
+	if err != nil {
+		log.Fatalf("Failed to load presets, %v", err)
 	}
 
-	outline_opts := &outline.OutlineOptions{
-		Contour:   contour_opts,
-		Trace:     trace_opts,
-		Rasterize: raster_opts,
+	outline_opts, err := presets.Apply(outline_flags.OutlineOptions(), outline_flags.Preset)
+
+	if err != nil {
+		log.Fatalf("Failed to derive outline options, %v", err)
 	}
 
 	r, err := os.Open(infile)
@@ -76,19 +67,69 @@ func main() {
 
 	defer r.Close()
 
-	im, _, err := image.Decode(r)
+	im, _, err := coloringbook.DecodeImageWithOptions(r, outline_flags.DecodeImageOptions())
 
 	if err != nil {
 		log.Fatalf("Failed to decode %s, %v", infile, err)
 	}
 
-	outline, err := outline.GenerateOutline(ctx, im, outline_opts)
+	im, alpha_mask := coloringbook.FlattenObjectImage(im, alpha_opts)
+
+	im, mask, err := coloringbook.PreprocessWithMask(ctx, im, preprocess)
+
+	if err != nil {
+		log.Fatalf("Failed to preprocess %s, %v", infile, err)
+	}
+
+	if mask_outfile != "" {
+
+		if mask == nil {
+			mask = coloringbook.ForegroundMask(im, coloringbook.DEFAULT_ISOLATE_TOLERANCE)
+		}
+
+		mask_wr, err := os.Create(mask_outfile)
+
+		if err != nil {
+			log.Fatalf("Failed to open %s for writing, %v", mask_outfile, err)
+		}
+
+		err = png.Encode(mask_wr, mask)
+
+		if err != nil {
+			log.Fatalf("Failed to encode %s, %v", mask_outfile, err)
+		}
+
+		err = mask_wr.Close()
+
+		if err != nil {
+			log.Fatalf("Failed to close %s after writing, %v", mask_outfile, err)
+		}
+	}
+
+	outline, err := outliner.Outline(ctx, im, outline_opts)
 
 	if err != nil {
 		log.Fatalf("Failed to generate outline, %v", err)
 	}
 
-	wr, err := os.OpenFile(outfile, os.O_RDWR|os.O_CREATE, 0644)
+	outline, err = coloringbook.AddAlphaEdge(ctx, outline, alpha_mask, alpha_opts)
+
+	if err != nil {
+		log.Fatalf("Failed to draw alpha edge, %v", err)
+	}
+
+	simplify_opts := outline_flags.SimplifyOptions()
+
+	if simplify_opts != nil {
+
+		outline, err = coloringbook.SimplifyOutline(ctx, outline, simplify_opts)
+
+		if err != nil {
+			log.Fatalf("Failed to simplify outline, %v", err)
+		}
+	}
+
+	wr, err := os.OpenFile(outfile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 
 	if err != nil {
 		log.Fatalf("Failed to open %s for writing, %v", outfile, err)
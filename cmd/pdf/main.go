@@ -1,32 +1,27 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
-	"image"
 	_ "image/jpeg"
-	"image/png"
+	"io"
 	"log"
 	"os"
-	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"time"
 
-	"github.com/aaronland/gocloud-blob-s3"
+	_ "github.com/aaronland/gocloud-blob-s3"
 	aa_bucket "github.com/aaronland/gocloud-blob/bucket"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/go-pdf/fpdf"
-	"github.com/nfnt/resize"
 	"github.com/sfomuseum/go-flags/flagset"
 	"github.com/sfomuseum/go-sfomuseum-coloringbook"
-	"github.com/sfomuseum/go-coloringbook/outline"
 	sfom_writer "github.com/sfomuseum/go-sfomuseum-writer/v3"
-	"github.com/tidwall/gjson"
 	"github.com/whosonfirst/go-reader"
 	_ "github.com/whosonfirst/go-reader-http"
-	"github.com/whosonfirst/go-whosonfirst-export/v2"
-	wof_reader "github.com/whosonfirst/go-whosonfirst-reader"
-	"github.com/whosonfirst/go-whosonfirst-uri"
-	gh_writer "github.com/whosonfirst/go-writer-github/v3"
 	"github.com/whosonfirst/go-writer/v3"
 	_ "gocloud.dev/blob/fileblob"
 )
@@ -42,18 +37,29 @@ func main() {
 	var update_object bool
 	var append_tree bool
 	var access_token_uri string
-
-	var contour_iterations int
-	var contour_scale float64
-	var contour_format string
-
-	var vtracer_precision int
-	var vtracer_speckle int
-
-	var use_batik bool
-	var path_batik string
+	var public_root_uri string
+	var variants string
+	var stage bool
+	var crop bool
+	var crop_padding int
+	var downscale bool
+	var ignore_object_options bool
+
+	var publish_acl string
+	var publish_cache_control string
+	var publish_content_disposition string
+	var publish_pdf_content_type string
+	var publish_thumbnail_content_type string
+	var publish_include_ids bool
+	var publish_metadata string
+
+	var pull_request bool
+	var pull_request_branch string
+	var pull_request_title string
+	var pull_request_description string
 
 	var mode string
+	var replay_source string
 
 	fs := flagset.NewFlagSet("coloringbook")
 
@@ -64,19 +70,33 @@ func main() {
 	fs.StringVar(&filename, "filename", "", "...")
 	fs.StringVar(&writer_uri, "writer-uri", "stdout://", "...")
 	fs.BoolVar(&update_object, "update-object", false, "...")
-	fs.StringVar(&mode, "mode", "cli", "...")
+	fs.StringVar(&mode, "mode", "cli", "Valid options are: cli, lambda, replay.")
+	fs.StringVar(&replay_source, "replay-source", "-", "The path to a file containing line-separated JSON-encoded ColoringBookRequest records to process when -mode is \"replay\". If \"-\" then records will be read from STDIN.")
 	fs.BoolVar(&append_tree, "append-tree", false, "...")
 	fs.StringVar(&access_token_uri, "access-token-uri", "", "...")
-
-	fs.IntVar(&contour_iterations, "contour-iteration", 8, "...")
-	fs.Float64Var(&contour_scale, "contour-scale", 1.0, "...")
-	fs.StringVar(&contour_format, "contour-format", "png", "...")
-
-	fs.IntVar(&vtracer_precision, "vtracer-precision", 6, "...")
-	fs.IntVar(&vtracer_speckle, "vtracer-speckle", 8, "...")
-
-	fs.BoolVar(&use_batik, "use-batik", true, "...")
-	fs.StringVar(&path_batik, "path-batik", "/usr/local/src/batik-1.17/batik-rasterizer-1.17.jar", "...")
+	fs.StringVar(&publish_acl, "publish-acl", "public-read", "The AWS S3 canned ACL to assign to published files. This is ignored by non-S3 buckets. If empty no ACL is assigned.")
+	fs.StringVar(&publish_cache_control, "publish-cache-control", "", "An optional Cache-Control header to assign to published files.")
+	fs.StringVar(&publish_content_disposition, "publish-content-disposition", "", "An optional Content-Disposition header to assign to published files. The string \"{filename}\" will be replaced by the base name of each published file.")
+	fs.StringVar(&publish_pdf_content_type, "publish-pdf-content-type", "application/pdf", "The Content-Type header to assign to published PDF files.")
+	fs.StringVar(&publish_thumbnail_content_type, "publish-thumbnail-content-type", "image/png", "The Content-Type header to assign to published thumbnail images.")
+	fs.BoolVar(&publish_include_ids, "publish-include-ids", true, "Assign the object and image IDs as custom metadata (\"object-id\" and \"image-id\") to published files.")
+	fs.StringVar(&publish_metadata, "publish-metadata", "", "An optional comma-separated list of key=value pairs to assign as custom metadata to published files.")
+
+	fs.BoolVar(&pull_request, "pull-request", false, "Gather all the object record updates for a run on a single branch and open a pull request for them, rather than committing each update directly. Requires that -writer-uri be a githubapi:// or githubapi-pr:// URI. Not supported when -mode is \"lambda\".")
+	fs.StringVar(&pull_request_branch, "pull-request-branch", "", "The name of the branch to write object record updates to when -pull-request is enabled. If empty a branch name will be derived from the current time.")
+	fs.StringVar(&pull_request_title, "pull-request-title", "Update coloring book properties", "The title of the pull request to open when -pull-request is enabled.")
+	fs.StringVar(&pull_request_description, "pull-request-description", "Update the millsfield:coloring_book properties of object records with newly published coloring book sheets.", "The description of the pull request to open when -pull-request is enabled.")
+	fs.BoolVar(&ignore_object_options, "ignore-object-options", false, "Do not apply the per-object outline options defined in the \"millsfield:coloring_book_options\" property of an object record.")
+	fs.BoolVar(&crop, "crop", false, "Crop outline images to the bounding box of their inked pixels, and enlarge them to fill the printable area, before they are laid out.")
+	fs.IntVar(&crop_padding, "crop-padding", 25, "The number of pixels of padding to leave around the inked pixels when -crop is enabled.")
+	fs.BoolVar(&downscale, "downscale", true, "Downscale source images to the resolution needed for the page layout before they are outlined. Ignored when -crop is enabled.")
+	fs.BoolVar(&stage, "stage", false, "Treat -bucket-uri as a staging bucket. Sheets are written with a manifest flagged as pending review, without an ACL, and object records are not updated until the sheet is approved (see cmd/approve).")
+	fs.StringVar(&variants, "variants", "", "An optional comma-separated list of difficulty variants (for example \"easy,medium,hard\") to publish for each object. Each variant is the name of a preset and is published as a separate file. The first variant is used for the top-level \"millsfield:coloring_book\" property and all the variants are listed in its \"variants\" property.")
+	fs.StringVar(&public_root_uri, "public-root-uri", "", "An optional URI to prepend to the filenames of published files when recording them in the \"millsfield:coloring_book\" property of an object record.")
+
+	outline_flags := coloringbook.AppendOutlineFlags(fs)
+	print_flags := coloringbook.AppendPrintFlags(fs)
+	quality_flags := coloringbook.AppendQualityFlags(fs)
 
 	flagset.Parse(fs)
 
@@ -115,256 +135,343 @@ func main() {
 
 	defer bucket.Close()
 
-	run := func(ctx context.Context, object_id int64) error {
+	custom_metadata, err := coloringbook.ParseMetadata(publish_metadata)
 
-		// Get object metadata
+	if err != nil {
+		log.Fatalf("Failed to parse -publish-metadata flag, %v", err)
+	}
 
-		body, err := wof_reader.LoadBytes(ctx, r, object_id)
+	publish_opts := &coloringbook.PublishOptions{
+		ACL:                  publish_acl,
+		CacheControl:         publish_cache_control,
+		ContentDisposition:   publish_content_disposition,
+		PDFContentType:       publish_pdf_content_type,
+		ThumbnailContentType: publish_thumbnail_content_type,
+		IncludeIds:           publish_include_ids,
+		Metadata:             custom_metadata,
+	}
 
-		if err != nil {
-			return fmt.Errorf("Failed to load feature for object, %v", err)
-		}
+	if stage {
+		publish_opts.ACL = ""
+	}
 
-		title_rsp := gjson.GetBytes(body, "properties.wof:name")
-		date_rsp := gjson.GetBytes(body, "properties.sfomuseum:date")
-		creditline_rsp := gjson.GetBytes(body, "properties.sfomuseum:creditline")
-		accession_number_rsp := gjson.GetBytes(body, "properties.sfomuseum:accession_number")
+	outliner, err := outline_flags.Outliner(ctx)
 
-		url := fmt.Sprintf("https://collection.sfomuseum.org/objects/%d/", object_id)
+	if err != nil {
+		log.Fatalf("Failed to create outliner, %v", err)
+	}
 
-		primary_rsp := gjson.GetBytes(body, "properties.millsfield:primary_image")
+	preprocess, err := outline_flags.PreprocessSteps()
 
-		if !primary_rsp.Exists() {
-			return fmt.Errorf("Object is missing primary image property")
-		}
+	if err != nil {
+		log.Fatalf("Failed to parse -preprocess flag, %v", err)
+	}
 
-		image_id := primary_rsp.Int()
+	print_opts, err := print_flags.PrintOptions()
 
-		// Derive contoured image if necessary
+	if err != nil {
+		log.Fatalf("Failed to derive print options, %v", err)
+	}
 
-		if object_image == "" {
+	quality_target, err := quality_flags.Target()
 
-			contour_opts := &outline.ContourOptions{
-				Iterations: contour_iterations,
-				Scale:      contour_scale,
-				Format:     contour_format,
-			}
+	if err != nil {
+		log.Fatalf("Failed to derive quality target, %v", err)
+	}
 
-			trace_opts := &outline.TraceOptions{
-				Precision: vtracer_precision,
-				Speckle:   vtracer_speckle,
-			}
+	alpha_opts, err := outline_flags.AlphaOptions()
 
-			raster_opts := &outline.RasterizeOptions{
-				UseBatik:  use_batik,
-				PathBatik: path_batik,
-			}
+	if err != nil {
+		log.Fatalf("Failed to derive alpha options, %v", err)
+	}
 
-			outline_opts := &outline.OutlineOptions{
-				Contour:   contour_opts,
-				Trace:     trace_opts,
-				Rasterize: raster_opts,
-			}
+	presets, err := outline_flags.Presets()
 
-			derive_opts := &coloringbook.DeriveObjectImageOptions{
-				Reader:  r,
-				Outline: outline_opts,
-			}
+	if err != nil {
+		log.Fatalf("Failed to load presets, %v", err)
+	}
 
-			derived_image, err := coloringbook.DeriveObjectImage(ctx, derive_opts, image_id)
+	// Set up a shared writer for object record updates if necessary
 
-			if err != nil {
-				return fmt.Errorf("Failed to derive object image, %v", err)
-			}
+	var pr_writer writer.Writer
+	pr_updates := int64(0)
 
-			defer os.Remove(derived_image)
+	if update_object && pull_request {
 
-			object_image = derived_image
+		if mode == "lambda" {
+			log.Fatalf("-pull-request is not supported in Lambda mode")
 		}
 
-		im_r, err := os.Open(object_image)
+		if pull_request_branch == "" {
+			pull_request_branch = fmt.Sprintf("coloringbook-%d", time.Now().Unix())
+		}
+
+		pr_uri, err := coloringbook.PullRequestWriterURI(writer_uri, pull_request_branch, pull_request_title, pull_request_description)
 
 		if err != nil {
-			return fmt.Errorf("Failed to open %s for reading, %w", object_image, err)
+			log.Fatalf("Failed to derive pull request writer URI, %v", err)
 		}
 
-		defer im_r.Close()
-
-		im, _, err := image.Decode(im_r)
+		wr, err := coloringbook.NewObjectRecordWriter(ctx, pr_uri, access_token_uri)
 
 		if err != nil {
-			return fmt.Errorf("Failed to decode image %s, %w", object_image, err)
+			log.Fatalf("Failed to create pull request writer, %v", err)
+		}
+
+		pr_writer = wr
+	}
+
+	default_variants := make([]string, 0)
+
+	for _, v := range strings.Split(variants, ",") {
+
+		v = strings.TrimSpace(v)
+
+		if v != "" {
+			default_variants = append(default_variants, v)
 		}
+	}
+
+	run := func(ctx context.Context, req *coloringbook.ColoringBookRequest) error {
+
+		// Copy flag values that are updated below so that successive invocations
+		// (in Lambda or replay mode) don't inherit the values of previous ones.
 
-		orientation := coloringbook.Orientation(im)
+		update_object := update_object
 
-		im_r.Seek(0, 0)
+		preset := req.Preset
 
-		// Create PDF
+		if preset == "" {
+			preset = outline_flags.Preset
+		}
+
+		variants := req.Variants
+
+		if len(variants) == 0 {
+			variants = default_variants
+		}
 
-		pdf := fpdf.New(orientation, "in", "Letter", "")
+		outline_opts, err := presets.Apply(outline_flags.OutlineOptions(), preset)
 
-		// Add sheet to colouring book
+		if err != nil {
+			return err
+		}
 
-		sheet_opts := &coloringbook.AddSheetOptions{
-			Image:           im,
-			ImagePath:       object_image,
-			ImageReader:     im_r,
-			URL:             url,
-			Title:           title_rsp.String(),
-			Date:            date_rsp.String(),
-			CreditLine:      creditline_rsp.String(),
-			AccessionNumber: accession_number_rsp.String(),
+		sheet_opts := &coloringbook.PublishSheetOptions{
+			Reader:              r,
+			Bucket:              bucket,
+			Publish:             publish_opts,
+			Outline:             outline_opts,
+			Outliner:            outliner,
+			Preprocess:          preprocess,
+			IgnoreObjectOptions: ignore_object_options,
+			ObjectImage:         object_image,
+			Filename:            filename,
+			AppendTree:          append_tree,
+			Stage:               stage,
+			Crop:                crop,
+			CropPadding:         crop_padding,
+			Print:               print_opts,
+			QualityTarget:       quality_target,
+			AutoTune:            quality_flags.AutoTune,
+			AutoTuneAttempts:    quality_flags.AutoTuneAttempts,
+			RejectQuality:       quality_flags.RejectQuality,
+			Simplify:            outline_flags.SimplifyOptions(),
+			Alpha:               alpha_opts,
+			Decode:              outline_flags.DecodeImageOptions(),
+			Downscale:           downscale,
+			Variants:            variants,
+			Presets:             presets,
 		}
 
-		err = coloringbook.AddSheet(ctx, pdf, sheet_opts)
+		manifests, body, err := coloringbook.PublishSheet(ctx, sheet_opts, req.ObjectId)
 
 		if err != nil {
-			return fmt.Errorf("Failed to add sheet, %v", err)
+			return err
 		}
 
-		// Publish PDF file
+		// Update object record (staged sheets are only recorded once they are approved)
 
-		if filename == "" {
-			filename = fmt.Sprintf("%d-%d-coloringbook.pdf", object_id, image_id)
+		if stage {
+			return nil
 		}
 
-		if append_tree {
+		var new_body []byte
+
+		if update_object {
+
+			coloringbook_prop := coloringbook.ManifestsColoringBookProperty(manifests, public_root_uri)
 
-			tree, err := uri.Id2Path(object_id)
+			has_updates, _body, err := coloringbook.AssignColoringBookProperties(ctx, body, coloringbook_prop)
 
 			if err != nil {
-				return fmt.Errorf("Failed to derive tree for object id %d, %w", object_id, err)
+				return err
+			}
+
+			if !has_updates {
+				update_object = false
 			}
 
-			filename = filepath.Join(tree, filename)
+			new_body = _body
 		}
 
-		pdf_wr, err := s3blob.NewWriterWithACL(ctx, bucket, filename, "public-read")
+		if update_object && pr_writer != nil {
 
-		if err != nil {
-			return fmt.Errorf("Failed to create new writer for %s, %v", filename, err)
-		}
+			_, err := sfom_writer.WriteBytes(ctx, pr_writer, new_body)
 
-		err = pdf.OutputAndClose(pdf_wr)
+			if err != nil {
+				return fmt.Errorf("Failed to add object record to pull request, %v", err)
+			}
 
-		if err != nil {
-			return fmt.Errorf("Failed to write %s, %v", filename, err)
+			atomic.AddInt64(&pr_updates, 1)
+
+		} else if update_object {
+
+			err := coloringbook.WriteObjectRecord(ctx, writer_uri, access_token_uri, new_body)
+
+			if err != nil {
+				return fmt.Errorf("Failed to update object record, %v", err)
+			}
 		}
 
-		log.Printf("Wrote %s\n", filename)
+		return nil
+	}
 
-		// Publish thumb
+	// Finally, run some code
 
-		thumb_im := resize.Thumbnail(600, 600, im, resize.Lanczos3)
+	closePullRequest := func() error {
 
-		thumb_filename := strings.Replace(filename, ".pdf", ".png", 1)
+		if pr_writer == nil || atomic.LoadInt64(&pr_updates) == 0 {
+			return nil
+		}
 
-		thumb_wr, err := s3blob.NewWriterWithACL(ctx, bucket, thumb_filename, "public-read")
+		err := pr_writer.Close(ctx)
 
 		if err != nil {
-			return fmt.Errorf("Failed to create new writer for %s, %v", thumb_filename, err)
+			return fmt.Errorf("Failed to create pull request, %v", err)
 		}
 
-		err = png.Encode(thumb_wr, thumb_im)
+		log.Printf("Created pull request for %d object record updates on branch %s\n", atomic.LoadInt64(&pr_updates), pull_request_branch)
+		return nil
+	}
+
+	switch mode {
+	case "cli":
+
+		req := &coloringbook.ColoringBookRequest{
+			ObjectId: object_id,
+		}
+
+		err := run(ctx, req)
 
 		if err != nil {
-			return fmt.Errorf("Failed to encode %s, %w", thumb_filename, err)
+			log.Fatal(err)
 		}
 
-		err = thumb_wr.Close()
+		err = closePullRequest()
 
 		if err != nil {
-			return fmt.Errorf("Failed to close %s, %w", thumb_filename, err)
+			log.Fatal(err)
 		}
 
-		log.Printf("Wrote %s\n", thumb_filename)
+	case "lambda":
+
+		lambda.Start(run)
 
-		// Update object record
+	case "replay":
 
-		var new_body []byte
+		var replay_r io.Reader
 
-		if update_object {
+		switch replay_source {
+		case "-":
+			replay_r = os.Stdin
+		default:
 
-			rsp := gjson.GetBytes(body, "properties.millsfield:has_coloring_book")
+			fh, err := os.Open(replay_source)
 
-			if rsp.Exists() && rsp.String() == "1" {
-				update_object = false
+			if err != nil {
+				log.Fatalf("Failed to open %s for reading, %v", replay_source, err)
 			}
+
+			defer fh.Close()
+			replay_r = fh
 		}
 
-		if update_object {
+		enc := json.NewEncoder(os.Stdout)
 
-			updates := map[string]interface{}{
-				"properties.millsfield:has_coloring_book": 1,
-			}
+		br := bufio.NewReader(replay_r)
 
-			has_updates, _body, err := export.AssignPropertiesIfChanged(ctx, body, updates)
+		// Physical line numbers in the replay source, including blank lines, so that results can be matched to the source
+		line_number := 0
+		requests := 0
+		failures := 0
 
-			if err != nil {
-				return fmt.Errorf("Failed to assign updates to object record, %v", err)
-			}
+		for {
 
-			if !has_updates {
-				update_object = false
+			body, read_err := br.ReadBytes('\n')
+
+			if read_err != nil && read_err != io.EOF {
+				log.Fatalf("Failed to read replay source, %v", read_err)
 			}
 
-			new_body = _body
-		}
+			// Reading the end of the source after a trailing newline returns an empty, unterminated line
 
-		if update_object {
+			if read_err == nil || len(body) > 0 {
+				line_number += 1
+			}
 
-			if access_token_uri != "" {
+			body = bytes.TrimSpace(body)
 
-				writer_uri, err = gh_writer.EnsureGitHubAccessToken(ctx, writer_uri, access_token_uri)
+			if len(body) > 0 {
 
-				if err != nil {
-					log.Fatalf("Failed to ensure access token, %v", err)
+				requests += 1
+
+				result := &coloringbook.ColoringBookResult{
+					Line: line_number,
 				}
 
-			}
+				var req *coloringbook.ColoringBookRequest
 
-			wr, err := writer.NewWriter(ctx, writer_uri)
+				err := json.Unmarshal(body, &req)
 
-			if err != nil {
-				log.Fatalf("Failed to create new writer, %v", err)
-			}
+				if err != nil {
+					err = fmt.Errorf("Failed to unmarshal request, %w", err)
+				} else if req == nil {
+					err = fmt.Errorf("Invalid request, %s", body)
+				} else {
+					result.ObjectId = req.ObjectId
+					err = run(ctx, req)
+				}
 
-			_, err = sfom_writer.WriteBytes(ctx, wr, new_body)
+				if err != nil {
+					failures += 1
+					result.Error = err.Error()
+				} else {
+					result.Ok = true
+				}
 
-			if err != nil {
-				return fmt.Errorf("Failed to update object record, %v", err)
-			}
+				err = enc.Encode(result)
 
-			err = wr.Close(ctx)
+				if err != nil {
+					log.Fatalf("Failed to encode result for line %d, %v", line_number, err)
+				}
+			}
 
-			if err != nil {
-				return fmt.Errorf("Failed to close object update writer, %v", err)
+			if read_err == io.EOF {
+				break
 			}
 		}
 
-		return nil
-	}
-
-	// Finally, run some code
-
-	switch mode {
-	case "cli":
-
-		err := run(ctx, object_id)
+		err := closePullRequest()
 
 		if err != nil {
 			log.Fatal(err)
 		}
 
-	case "lambda":
-
-		handler := func(ctx context.Context, req *coloringbook.ColoringBookRequest) error {
-			return run(ctx, req.ObjectId)
+		if failures > 0 {
+			log.Fatalf("%d of %d requests failed", failures, requests)
 		}
 
-		lambda.Start(handler)
-
 	default:
 		log.Fatalf("Invalid mode")
 	}
@@ -0,0 +1,615 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"image"
+	"image/png"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	_ "github.com/aaronland/gocloud-blob-s3"
+	aa_bucket "github.com/aaronland/gocloud-blob/bucket"
+	"github.com/nfnt/resize"
+	"github.com/sfomuseum/go-coloringbook/outline"
+	"github.com/sfomuseum/go-flags/flagset"
+	"github.com/sfomuseum/go-sfomuseum-coloringbook"
+	"github.com/tidwall/gjson"
+	"github.com/whosonfirst/go-reader"
+	_ "github.com/whosonfirst/go-reader-http"
+	wof_reader "github.com/whosonfirst/go-whosonfirst-reader"
+	_ "gocloud.dev/blob/fileblob"
+)
+
+//go:embed index.html
+var index_html string
+
+type reviewURIs struct {
+	Index    string
+	Original string
+	Outline  string
+	Save     string
+	Publish  string
+}
+
+type reviewVars struct {
+	ObjectId   int64
+	Title      string
+	Options    *outline.OutlineOptions
+	Preprocess string
+	URIs       *reviewURIs
+}
+
+func main() {
+
+	var server_uri string
+	var reader_uri string
+	var writer_uri string
+	var bucket_uri string
+	var access_token_uri string
+	var public_root_uri string
+	var update_object bool
+	var append_tree bool
+	var stage bool
+	var crop bool
+	var crop_padding int
+	var downscale bool
+	var publish_acl string
+	var preview_size uint
+
+	fs := flagset.NewFlagSet("coloringbook")
+
+	fs.StringVar(&server_uri, "server-uri", "localhost:8080", "The host and port to listen for requests on.")
+	fs.StringVar(&reader_uri, "reader-uri", "https://static.sfomuseum.org/data/", "A valid whosonfirst/go-reader URI used to read object and image records.")
+	fs.StringVar(&writer_uri, "writer-uri", "stdout://", "A valid whosonfirst/go-writer URI used to write updated object records.")
+	fs.StringVar(&bucket_uri, "bucket-uri", "cwd://", "A valid gocloud.dev/blob URI for the bucket where sheets are published.")
+	fs.StringVar(&access_token_uri, "access-token-uri", "", "An optional gocloud.dev/runtimevar URI referencing a GitHub access token to use with -writer-uri.")
+	fs.StringVar(&public_root_uri, "public-root-uri", "", "An optional URI to prepend to the filenames of published files when recording them in the \"millsfield:coloring_book\" property of an object record.")
+	fs.BoolVar(&update_object, "update-object", false, "Update the object record when a sheet is published.")
+	fs.BoolVar(&append_tree, "append-tree", false, "Publish files using a Who's On First -style tree.")
+	fs.BoolVar(&crop, "crop", false, "Crop outline images to the bounding box of their inked pixels, and enlarge them to fill the printable area, before they are laid out.")
+	fs.IntVar(&crop_padding, "crop-padding", 25, "The number of pixels of padding to leave around the inked pixels when -crop is enabled.")
+	fs.BoolVar(&downscale, "downscale", true, "Downscale source images to the resolution needed for the page layout before they are outlined. Ignored when -crop is enabled.")
+	fs.BoolVar(&stage, "stage", false, "Treat -bucket-uri as a staging bucket and flag published sheets as pending review (see cmd/approve).")
+	fs.StringVar(&publish_acl, "publish-acl", "", "The AWS S3 canned ACL to assign to published files. This is ignored by non-S3 buckets. If empty no ACL is assigned.")
+	fs.UintVar(&preview_size, "preview-size", 1200, "The maximum width or height of the original image, in pixels, to display and to outline when previewing.")
+
+	outline_flags := coloringbook.AppendOutlineFlags(fs)
+	print_flags := coloringbook.AppendPrintFlags(fs)
+	quality_flags := coloringbook.AppendQualityFlags(fs)
+
+	flagset.Parse(fs)
+
+	err := flagset.SetFlagsFromEnvVars(fs, "SFOMUSEUM")
+
+	if err != nil {
+		log.Fatalf("Failed to set flags from environment variables, %v", err)
+	}
+
+	ctx := context.Background()
+
+	r, err := reader.NewReader(ctx, reader_uri)
+
+	if err != nil {
+		log.Fatalf("Failed to create reader, %v", err)
+	}
+
+	bucket, err := aa_bucket.OpenBucket(ctx, bucket_uri)
+
+	if err != nil {
+		log.Fatalf("Failed to open bucket, %v", err)
+	}
+
+	defer bucket.Close()
+
+	t, err := template.New("index").Parse(index_html)
+
+	if err != nil {
+		log.Fatalf("Failed to parse index template, %v", err)
+	}
+
+	uris := &reviewURIs{
+		Index:    "/",
+		Original: "/original",
+		Outline:  "/outline",
+		Save:     "/save",
+		Publish:  "/publish",
+	}
+
+	publish_opts := &coloringbook.PublishOptions{
+		ACL:                  publish_acl,
+		PDFContentType:       "application/pdf",
+		ThumbnailContentType: "image/png",
+		IncludeIds:           true,
+	}
+
+	outliner, err := outline_flags.Outliner(ctx)
+
+	if err != nil {
+		log.Fatalf("Failed to create outliner, %v", err)
+	}
+
+	default_preprocess, err := outline_flags.PreprocessSteps()
+
+	if err != nil {
+		log.Fatalf("Failed to parse -preprocess flag, %v", err)
+	}
+
+	print_opts, err := print_flags.PrintOptions()
+
+	if err != nil {
+		log.Fatalf("Failed to derive print options, %v", err)
+	}
+
+	quality_target, err := quality_flags.Target()
+
+	if err != nil {
+		log.Fatalf("Failed to derive quality target, %v", err)
+	}
+
+	alpha_opts, err := outline_flags.AlphaOptions()
+
+	if err != nil {
+		log.Fatalf("Failed to derive alpha options, %v", err)
+	}
+
+	// Derive the preprocess steps for a request, using the request parameter if present and otherwise the -preprocess flag.
+
+	preprocessSteps := func(q url.Values) ([]*coloringbook.PreprocessStep, error) {
+
+		if !q.Has("preprocess") {
+			return default_preprocess, nil
+		}
+
+		return coloringbook.ParsePreprocessSteps(q.Get("preprocess"))
+	}
+
+	presets, err := outline_flags.Presets()
+
+	if err != nil {
+		log.Fatalf("Failed to load presets, %v", err)
+	}
+
+	default_opts, err := presets.Apply(outline_flags.OutlineOptions(), outline_flags.Preset)
+
+	if err != nil {
+		log.Fatalf("Failed to derive outline options, %v", err)
+	}
+
+	// Derive the outline options for a request, starting with the defaults defined by the command line
+	// flags (and -preset), then any per-object options defined in the object record and finally the request parameters.
+
+	outlineOptions := func(ctx context.Context, object_id int64, q url.Values) (*outline.OutlineOptions, error) {
+
+		body, err := wof_reader.LoadBytes(ctx, r, object_id)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load record for object %d, %w", object_id, err)
+		}
+
+		opts, err := coloringbook.ObjectOutlineOptions(default_opts, body)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// Request parameters are applied as overrides using MergeOutlineOptions so that they
+		// are validated in the same way as the per-object options in the object record.
+
+		contour_overrides := make(map[string]any)
+		trace_overrides := make(map[string]any)
+
+		int_params := map[string]struct {
+			overrides map[string]any
+			key       string
+		}{
+			"contour-iterations": {contour_overrides, "iterations"},
+			"vtracer-precision":  {trace_overrides, "precision"},
+			"vtracer-speckle":    {trace_overrides, "speckle"},
+		}
+
+		for k, p := range int_params {
+
+			v := q.Get(k)
+
+			if v == "" {
+				continue
+			}
+
+			i, err := strconv.Atoi(v)
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s parameter, %w", k, err)
+			}
+
+			p.overrides[p.key] = i
+		}
+
+		str_scale := q.Get("contour-scale")
+
+		if str_scale != "" {
+
+			scale, err := strconv.ParseFloat(str_scale, 64)
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid contour-scale parameter, %w", err)
+			}
+
+			contour_overrides["scale"] = scale
+		}
+
+		overrides := map[string]any{
+			"contour": contour_overrides,
+			"trace":   trace_overrides,
+		}
+
+		enc_overrides, err := json.Marshal(overrides)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to marshal outline options, %w", err)
+		}
+
+		opts, err = coloringbook.MergeOutlineOptions(opts, enc_overrides)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid outline options, %w", err)
+		}
+
+		return opts, nil
+	}
+
+	objectId := func(q url.Values) (int64, error) {
+
+		object_id, err := strconv.ParseInt(q.Get("object_id"), 10, 64)
+
+		if err != nil {
+			return 0, fmt.Errorf("Invalid object_id parameter, %w", err)
+		}
+
+		return object_id, nil
+	}
+
+	// Original images are cached so that they are only fetched once per object
+
+	originals := new(sync.Map)
+
+	originalImage := func(ctx context.Context, object_id int64) (image.Image, error) {
+
+		v, exists := originals.Load(object_id)
+
+		if exists {
+			return v.(image.Image), nil
+		}
+
+		body, err := wof_reader.LoadBytes(ctx, r, object_id)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load record for object %d, %w", object_id, err)
+		}
+
+		primary_rsp := gjson.GetBytes(body, "properties.millsfield:primary_image")
+
+		if !primary_rsp.Exists() {
+			return nil, fmt.Errorf("Object %d is missing primary image property", object_id)
+		}
+
+		im, err := coloringbook.FetchObjectImageWithOptions(ctx, r, primary_rsp.Int(), outline_flags.DecodeImageOptions())
+
+		if err != nil {
+			return nil, err
+		}
+
+		im = resize.Thumbnail(preview_size, preview_size, im, resize.Lanczos3)
+
+		originals.Store(object_id, im)
+		return im, nil
+	}
+
+	index_handler := func(rsp http.ResponseWriter, req *http.Request) {
+
+		q := req.URL.Query()
+
+		vars := reviewVars{
+			URIs: uris,
+		}
+
+		if q.Get("object_id") != "" {
+
+			object_id, err := objectId(q)
+
+			if err != nil {
+				http.Error(rsp, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			body, err := wof_reader.LoadBytes(req.Context(), r, object_id)
+
+			if err != nil {
+				http.Error(rsp, err.Error(), http.StatusNotFound)
+				return
+			}
+
+			opts, err := outlineOptions(req.Context(), object_id, q)
+
+			if err != nil {
+				http.Error(rsp, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			steps, err := preprocessSteps(q)
+
+			if err != nil {
+				http.Error(rsp, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			vars.ObjectId = object_id
+			vars.Title = gjson.GetBytes(body, "properties.wof:name").String()
+			vars.Options = opts
+			vars.Preprocess = coloringbook.FormatPreprocessSteps(steps)
+		}
+
+		rsp.Header().Set("Content-Type", "text/html")
+
+		err := t.Execute(rsp, vars)
+
+		if err != nil {
+			log.Printf("Failed to render index, %v", err)
+		}
+	}
+
+	original_handler := func(rsp http.ResponseWriter, req *http.Request) {
+
+		object_id, err := objectId(req.URL.Query())
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		im, err := originalImage(req.Context(), object_id)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		rsp.Header().Set("Content-Type", "image/png")
+		png.Encode(rsp, im)
+	}
+
+	outline_handler := func(rsp http.ResponseWriter, req *http.Request) {
+
+		q := req.URL.Query()
+
+		object_id, err := objectId(q)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		opts, err := outlineOptions(req.Context(), object_id, q)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Previews are always rendered as PNG images. opts is a copy so this doesn't affect published sheets.
+		opts.Contour.Format = "png"
+
+		steps, err := preprocessSteps(q)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		im, err := originalImage(req.Context(), object_id)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		im, alpha_mask := coloringbook.FlattenObjectImage(im, alpha_opts)
+
+		im, err = coloringbook.Preprocess(req.Context(), im, steps)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		o, err := outliner.Outline(req.Context(), im, opts)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		o, err = coloringbook.AddAlphaEdge(req.Context(), o, alpha_mask, alpha_opts)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		rsp.Header().Set("Content-Type", "image/png")
+
+		err = o.Write(req.Context(), rsp)
+
+		if err != nil {
+			log.Printf("Failed to write outline for %d, %v", object_id, err)
+		}
+	}
+
+	save_handler := func(rsp http.ResponseWriter, req *http.Request) {
+
+		if req.Method != http.MethodPost {
+			http.Error(rsp, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		err := req.ParseForm()
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		object_id, err := objectId(req.PostForm)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		opts, err := outlineOptions(req.Context(), object_id, req.PostForm)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		body, err := wof_reader.LoadBytes(req.Context(), r, object_id)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		has_updates, new_body, err := coloringbook.AssignColoringBookOptions(req.Context(), body, coloringbook.NewOutlineProperty(opts))
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if !has_updates {
+			rsp.Write([]byte("Settings are unchanged."))
+			return
+		}
+
+		err = coloringbook.WriteObjectRecord(req.Context(), writer_uri, access_token_uri, new_body)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		rsp.Write([]byte(fmt.Sprintf("Saved settings for object %d.", object_id)))
+	}
+
+	publish_handler := func(rsp http.ResponseWriter, req *http.Request) {
+
+		if req.Method != http.MethodPost {
+			http.Error(rsp, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		err := req.ParseForm()
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		object_id, err := objectId(req.PostForm)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		opts, err := outlineOptions(req.Context(), object_id, req.PostForm)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		steps, err := preprocessSteps(req.PostForm)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sheet_opts := &coloringbook.PublishSheetOptions{
+			Reader:     r,
+			Bucket:     bucket,
+			Publish:    publish_opts,
+			Outline:    opts,
+			Outliner:   outliner,
+			Preprocess: steps,
+			// opts already includes any per-object options
+			IgnoreObjectOptions: true,
+			AppendTree:          append_tree,
+			Stage:               stage,
+			Crop:                crop,
+			CropPadding:         crop_padding,
+			Print:               print_opts,
+			QualityTarget:       quality_target,
+			AutoTune:            quality_flags.AutoTune,
+			AutoTuneAttempts:    quality_flags.AutoTuneAttempts,
+			RejectQuality:       quality_flags.RejectQuality,
+			Simplify:            outline_flags.SimplifyOptions(),
+			Alpha:               alpha_opts,
+			Decode:              outline_flags.DecodeImageOptions(),
+			Downscale:           downscale,
+		}
+
+		manifests, body, err := coloringbook.PublishSheet(req.Context(), sheet_opts, object_id)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if update_object && !stage {
+
+			has_updates, new_body, err := coloringbook.AssignColoringBookProperties(req.Context(), body, coloringbook.ManifestsColoringBookProperty(manifests, public_root_uri))
+
+			if err != nil {
+				http.Error(rsp, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if has_updates {
+
+				err = coloringbook.WriteObjectRecord(req.Context(), writer_uri, access_token_uri, new_body)
+
+				if err != nil {
+					http.Error(rsp, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+
+		rsp.Write([]byte(fmt.Sprintf("Published %s.", manifests[0].PDF)))
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(uris.Index, index_handler)
+	mux.HandleFunc(uris.Original, original_handler)
+	mux.HandleFunc(uris.Outline, outline_handler)
+	mux.HandleFunc(uris.Save, save_handler)
+	mux.HandleFunc(uris.Publish, publish_handler)
+
+	log.Printf("Listening for requests on http://%s\n", server_uri)
+
+	err = http.ListenAndServe(server_uri, mux)
+
+	if err != nil {
+		log.Fatalf("Failed to serve requests, %v", err)
+	}
+}
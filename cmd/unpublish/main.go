@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	aa_bucket "github.com/aaronland/gocloud-blob/bucket"
+	"github.com/sfomuseum/go-flags/flagset"
+	"github.com/sfomuseum/go-sfomuseum-coloringbook"
+	"github.com/whosonfirst/go-reader"
+	_ "github.com/whosonfirst/go-reader-http"
+	wof_reader "github.com/whosonfirst/go-whosonfirst-reader"
+	_ "gocloud.dev/blob/fileblob"
+)
+
+func main() {
+
+	var reader_uri string
+	var writer_uri string
+	var bucket_uri string
+	var update_object bool
+	var append_tree bool
+	var access_token_uri string
+	var dryrun bool
+
+	fs := flagset.NewFlagSet("coloringbook")
+
+	fs.StringVar(&reader_uri, "reader-uri", "https://static.sfomuseum.org/data/", "A valid whosonfirst/go-reader URI used to read object records.")
+	fs.StringVar(&bucket_uri, "bucket-uri", "cwd://", "A valid gocloud.dev/blob URI for the bucket where coloring book sheets are published.")
+	fs.StringVar(&writer_uri, "writer-uri", "stdout://", "A valid whosonfirst/go-writer URI used to write updated object records.")
+	fs.BoolVar(&update_object, "update-object", true, "Remove coloring book properties from the object record.")
+	fs.BoolVar(&append_tree, "append-tree", false, "Sheets were published using a Who's On First -style tree.")
+	fs.StringVar(&access_token_uri, "access-token-uri", "", "An optional gocloud.dev/runtimevar URI referencing a GitHub access token to use with -writer-uri.")
+	fs.BoolVar(&dryrun, "dryrun", false, "List the files and properties that would be removed without removing them.")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Remove published coloring book sheets, and the properties referencing them, for one or more objects.\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n\t %s [options] object_id(N) object_id(N)\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	flagset.Parse(fs)
+
+	err := flagset.SetFlagsFromEnvVars(fs, "SFOMUSEUM")
+
+	if err != nil {
+		log.Fatalf("Failed to set flags from environment variables, %v", err)
+	}
+
+	ctx := context.Background()
+
+	r, err := reader.NewReader(ctx, reader_uri)
+
+	if err != nil {
+		log.Fatalf("Failed to create reader, %v", err)
+	}
+
+	if bucket_uri == "cwd://" {
+
+		cwd, err := os.Getwd()
+
+		if err != nil {
+			log.Fatalf("Failed to derive current working directory, %v", err)
+		}
+
+		bucket_uri = fmt.Sprintf("file://%s", cwd)
+	}
+
+	bucket, err := aa_bucket.OpenBucket(ctx, bucket_uri)
+
+	if err != nil {
+		log.Fatalf("Failed to open bucket, %v", err)
+	}
+
+	defer bucket.Close()
+
+	unpublish_opts := &coloringbook.UnpublishOptions{
+		Bucket:     bucket,
+		Reader:     r,
+		AppendTree: append_tree,
+		DryRun:     dryrun,
+	}
+
+	for _, str_id := range fs.Args() {
+
+		object_id, err := strconv.ParseInt(str_id, 10, 64)
+
+		if err != nil {
+			log.Fatalf("Invalid object ID '%s', %v", str_id, err)
+		}
+
+		_, err = coloringbook.Unpublish(ctx, unpublish_opts, object_id)
+
+		if err != nil {
+			log.Fatalf("Failed to unpublish files for object %d, %v", object_id, err)
+		}
+
+		if !update_object {
+			continue
+		}
+
+		body, err := wof_reader.LoadBytes(ctx, r, object_id)
+
+		if err != nil {
+			log.Fatalf("Failed to load record for object %d, %v", object_id, err)
+		}
+
+		has_updates, new_body, err := coloringbook.RemoveColoringBookProperties(ctx, body)
+
+		if err != nil {
+			log.Fatalf("Failed to remove coloring book properties for object %d, %v", object_id, err)
+		}
+
+		if !has_updates {
+			continue
+		}
+
+		if dryrun {
+			log.Printf("[dryrun] Remove coloring book properties from object %d\n", object_id)
+			continue
+		}
+
+		err = coloringbook.WriteObjectRecord(ctx, writer_uri, access_token_uri, new_body)
+
+		if err != nil {
+			log.Fatalf("Failed to update record for object %d, %v", object_id, err)
+		}
+
+		log.Printf("Removed coloring book properties from object %d\n", object_id)
+	}
+}
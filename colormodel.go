@@ -0,0 +1,24 @@
+package coloringbook
+
+import (
+	"image"
+	"image/draw"
+)
+
+// NormalizeColorModel returns 'im' converted to an 8-bit (non-premultiplied) RGBA image if it uses a colour model other
+// than 8-bit RGB(A), YCbCr or grayscale. For example CMYK, 16-bit and paletted images, as decoded from TIFF, PNG and GIF
+// files, are converted. Images which already use an 8-bit colour model are returned unchanged.
+func NormalizeColorModel(im image.Image) image.Image {
+
+	switch im.(type) {
+	case *image.RGBA, *image.NRGBA, *image.YCbCr, *image.Gray:
+		return im
+	}
+
+	bounds := im.Bounds()
+
+	rgba := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), im, bounds.Min, draw.Src)
+
+	return rgba
+}
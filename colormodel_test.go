@@ -0,0 +1,328 @@
+package coloringbook
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"testing"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// testColors are the pixels, in row-major order, of the 2x2 test images.
+var testColors = []color.NRGBA{
+	{0xff, 0x00, 0x00, 0xff},
+	{0x00, 0xff, 0x00, 0xff},
+	{0x00, 0x00, 0xff, 0xff},
+	{0xff, 0xff, 0xff, 0xff},
+}
+
+// testCMYKColors are the CMYK equivalents of testColors.
+var testCMYKColors = []color.CMYK{
+	{0x00, 0xff, 0xff, 0x00},
+	{0xff, 0x00, 0xff, 0x00},
+	{0xff, 0xff, 0x00, 0x00},
+	{0x00, 0x00, 0x00, 0x00},
+}
+
+func TestDecodeImageWithOptions(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		body   func(t *testing.T) []byte
+		format string
+	}{
+		{"16-bit tiff", encodeTestTIFF16, "tiff"},
+		{"cmyk tiff", func(t *testing.T) []byte { return encodeTestCMYKTIFF(t, false) }, "tiff"},
+		{"cmyk tiff deflate predictor", func(t *testing.T) []byte { return encodeTestCMYKTIFF(t, true) }, "tiff"},
+		{"bmp", encodeTestBMP, "bmp"},
+		{"paletted gif", encodeTestGIF, "gif"},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			im, format, err := DecodeImageWithOptions(bytes.NewReader(test.body(t)), nil)
+
+			if err != nil {
+				t.Fatalf("Failed to decode image, %v", err)
+			}
+
+			if format != test.format {
+				t.Fatalf("Expected format %s, got %s", test.format, format)
+			}
+
+			assertEightBitImage(t, im)
+
+			for i, expected := range testColors {
+
+				x := i % 2
+				y := i / 2
+
+				got := color.NRGBAModel.Convert(im.At(x, y)).(color.NRGBA)
+
+				if got != expected {
+					t.Fatalf("Expected %v at (%d,%d), got %v", expected, x, y, got)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeImageWithOptionsWebP(t *testing.T) {
+
+	webp_body, err := os.ReadFile("testdata/gopher-doc.1bpp.lossless.webp")
+
+	if err != nil {
+		t.Fatalf("Failed to read WebP fixture, %v", err)
+	}
+
+	png_body, err := os.ReadFile("testdata/gopher-doc.1bpp.png")
+
+	if err != nil {
+		t.Fatalf("Failed to read PNG fixture, %v", err)
+	}
+
+	im, format, err := DecodeImageWithOptions(bytes.NewReader(webp_body), nil)
+
+	if err != nil {
+		t.Fatalf("Failed to decode WebP image, %v", err)
+	}
+
+	if format != "webp" {
+		t.Fatalf("Expected format webp, got %s", format)
+	}
+
+	assertEightBitImage(t, im)
+
+	expected, err := png.Decode(bytes.NewReader(png_body))
+
+	if err != nil {
+		t.Fatalf("Failed to decode PNG fixture, %v", err)
+	}
+
+	if im.Bounds() != expected.Bounds() {
+		t.Fatalf("Expected bounds %v, got %v", expected.Bounds(), im.Bounds())
+	}
+
+	bounds := im.Bounds()
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+
+			got := color.NRGBAModel.Convert(im.At(x, y))
+			want := color.NRGBAModel.Convert(expected.At(x, y))
+
+			if got != want {
+				t.Fatalf("Expected %v at (%d,%d), got %v", want, x, y, got)
+			}
+		}
+	}
+}
+
+func TestDecodeCMYKTIFFConfig(t *testing.T) {
+
+	_, format, err := CheckPixelBudget(encodeTestCMYKTIFF(t, false), 4)
+
+	if err != nil {
+		t.Fatalf("Failed to check pixel budget, %v", err)
+	}
+
+	if format != "tiff" {
+		t.Fatalf("Expected format tiff, got %s", format)
+	}
+
+	_, _, err = CheckPixelBudget(encodeTestCMYKTIFF(t, false), 3)
+
+	if err == nil {
+		t.Fatalf("Expected CMYK TIFF image to exceed pixel budget")
+	}
+}
+
+func assertEightBitImage(t *testing.T, im image.Image) {
+
+	t.Helper()
+
+	switch im.(type) {
+	case *image.NRGBA, *image.RGBA:
+		// pass
+	default:
+		t.Fatalf("Expected 8-bit NRGBA or RGBA image, got %T", im)
+	}
+}
+
+func newTestNRGBA() *image.NRGBA {
+
+	im := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+
+	for i, c := range testColors {
+		im.SetNRGBA(i%2, i/2, c)
+	}
+
+	return im
+}
+
+func encodeTestTIFF16(t *testing.T) []byte {
+
+	im := image.NewNRGBA64(image.Rect(0, 0, 2, 2))
+
+	for i, c := range testColors {
+		im.Set(i%2, i/2, c)
+	}
+
+	var buf bytes.Buffer
+
+	err := tiff.Encode(&buf, im, nil)
+
+	if err != nil {
+		t.Fatalf("Failed to encode TIFF image, %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func encodeTestBMP(t *testing.T) []byte {
+
+	var buf bytes.Buffer
+
+	err := bmp.Encode(&buf, newTestNRGBA())
+
+	if err != nil {
+		t.Fatalf("Failed to encode BMP image, %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func encodeTestGIF(t *testing.T) []byte {
+
+	palette := make(color.Palette, len(testColors))
+
+	for i, c := range testColors {
+		palette[i] = c
+	}
+
+	im := image.NewPaletted(image.Rect(0, 0, 2, 2), palette)
+
+	for i := range testColors {
+		im.SetColorIndex(i%2, i/2, uint8(i))
+	}
+
+	var buf bytes.Buffer
+
+	err := gif.Encode(&buf, im, nil)
+
+	if err != nil {
+		t.Fatalf("Failed to encode GIF image, %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+// encodeTestCMYKTIFF returns a little-endian, single strip, 8-bit CMYK TIFF file containing testCMYKColors. If 'deflate'
+// is true the strip is Deflate compressed with a horizontal predictor.
+func encodeTestCMYKTIFF(t *testing.T, deflate bool) []byte {
+
+	pix := make([]byte, 0, len(testCMYKColors)*4)
+
+	for _, c := range testCMYKColors {
+		pix = append(pix, c.C, c.M, c.Y, c.K)
+	}
+
+	compression := uint32(tiff_compression_none)
+	predictor := uint32(1)
+
+	if deflate {
+
+		// Apply horizontal differencing, right to left, to each row
+
+		for y := 0; y < 2; y++ {
+
+			row := pix[y*8 : (y+1)*8]
+
+			for i := len(row) - 1; i >= 4; i-- {
+				row[i] -= row[i-4]
+			}
+		}
+
+		var buf bytes.Buffer
+
+		wr := zlib.NewWriter(&buf)
+
+		_, err := wr.Write(pix)
+
+		if err != nil {
+			t.Fatalf("Failed to compress strip, %v", err)
+		}
+
+		err = wr.Close()
+
+		if err != nil {
+			t.Fatalf("Failed to close compressor, %v", err)
+		}
+
+		pix = buf.Bytes()
+		compression = uint32(tiff_compression_deflate)
+		predictor = uint32(tiff_predictor_horizontal)
+	}
+
+	entries := []struct {
+		tag   uint16
+		kind  uint16
+		value uint32
+	}{
+		{tiff_tag_width, 3, 2},
+		{tiff_tag_height, 3, 2},
+		{tiff_tag_bits_per_sample, 3, 8},
+		{tiff_tag_compression, 3, compression},
+		{tiff_tag_photometric, 3, uint32(tiff_photometric_separated)},
+		{tiff_tag_strip_offsets, 4, 0},
+		{tiff_tag_samples_per_pixel, 3, 4},
+		{tiff_tag_rows_per_strip, 3, 2},
+		{tiff_tag_strip_byte_counts, 4, uint32(len(pix))},
+		{tiff_tag_planar_config, 3, uint32(tiff_planar_config_contiguous)},
+		{tiff_tag_predictor, 3, predictor},
+	}
+
+	// Header, IFD (count, entries, next IFD offset) and then the strip
+
+	ifd_size := 2 + len(entries)*12 + 4
+	strip_offset := uint32(8 + ifd_size)
+
+	order := binary.LittleEndian
+	body := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+
+	body = order.AppendUint16(body, uint16(len(entries)))
+
+	for _, e := range entries {
+
+		value := e.value
+
+		if e.tag == tiff_tag_strip_offsets {
+			value = strip_offset
+		}
+
+		body = order.AppendUint16(body, e.tag)
+		body = order.AppendUint16(body, e.kind)
+		body = order.AppendUint32(body, 1)
+
+		if e.kind == 3 {
+			body = order.AppendUint16(body, uint16(value))
+			body = order.AppendUint16(body, 0)
+		} else {
+			body = order.AppendUint32(body, value)
+		}
+	}
+
+	body = order.AppendUint32(body, 0)
+	body = append(body, pix...)
+
+	return body
+}
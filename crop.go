@@ -0,0 +1,116 @@
+package coloringbook
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// INK_THRESHOLD is the luminance (0-255) below which a pixel in an outline image is considered to be inked.
+const INK_THRESHOLD uint8 = 128
+
+// InkBounds returns the bounding box of the inked pixels (opaque pixels whose luminance is below INK_THRESHOLD)
+// in 'im' and a boolean flag indicating whether any inked pixels were found.
+func InkBounds(im image.Image) (image.Rectangle, bool) {
+
+	bounds := im.Bounds()
+
+	min_x := bounds.Max.X
+	min_y := bounds.Max.Y
+	max_x := bounds.Min.X - 1
+	max_y := bounds.Min.Y - 1
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+
+			c := color.NRGBAModel.Convert(im.At(x, y)).(color.NRGBA)
+
+			if c.A < 0x80 {
+				continue
+			}
+
+			g := color.GrayModel.Convert(c).(color.Gray)
+
+			if g.Y >= INK_THRESHOLD {
+				continue
+			}
+
+			if x < min_x {
+				min_x = x
+			}
+
+			if x > max_x {
+				max_x = x
+			}
+
+			if y < min_y {
+				min_y = y
+			}
+
+			if y > max_y {
+				max_y = y
+			}
+		}
+	}
+
+	if max_x < min_x || max_y < min_y {
+		return image.Rectangle{}, false
+	}
+
+	return image.Rect(min_x, min_y, max_x+1, max_y+1), true
+}
+
+// inkMask returns a (row-major) list of flags indicating whether each pixel in 'im' is inked, as defined by InkBounds.
+func inkMask(im image.Image) []bool {
+
+	bounds := im.Bounds()
+
+	w := bounds.Dx()
+	h := bounds.Dy()
+
+	mask := make([]bool, w*h)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+
+			c := color.NRGBAModel.Convert(im.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+
+			if c.A < 0x80 {
+				continue
+			}
+
+			g := color.GrayModel.Convert(c).(color.Gray)
+			mask[y*w+x] = g.Y < INK_THRESHOLD
+		}
+	}
+
+	return mask
+}
+
+// CropToContent returns a copy of 'im' cropped to the bounding box of its inked pixels, as determined by InkBounds,
+// expanded by 'padding' pixels on each side (but not beyond the bounds of 'im'). If 'im' has no inked pixels it is
+// returned unchanged.
+func CropToContent(im image.Image, padding int) image.Image {
+
+	ink, ok := InkBounds(im)
+
+	if !ok {
+		return im
+	}
+
+	if padding < 0 {
+		padding = 0
+	}
+
+	crop := image.Rect(ink.Min.X-padding, ink.Min.Y-padding, ink.Max.X+padding, ink.Max.Y+padding)
+	crop = crop.Intersect(im.Bounds())
+
+	if crop.Eq(im.Bounds()) {
+		return im
+	}
+
+	cropped := image.NewRGBA(image.Rect(0, 0, crop.Dx(), crop.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), im, crop.Min, draw.Src)
+
+	return cropped
+}
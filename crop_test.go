@@ -0,0 +1,158 @@
+package coloringbook
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// newTestOutline returns a white 'w' x 'h' image with the pixels in 'ink' filled black.
+func newTestOutline(w int, h int, ink ...image.Rectangle) *image.RGBA {
+
+	im := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(im, im.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	for _, r := range ink {
+		draw.Draw(im, r, image.NewUniform(color.Black), image.Point{}, draw.Src)
+	}
+
+	return im
+}
+
+func TestInkBounds(t *testing.T) {
+
+	tests := []struct {
+		im       image.Image
+		expected image.Rectangle
+		ok       bool
+	}{
+		{newTestOutline(100, 50), image.Rectangle{}, false},
+		{newTestOutline(100, 50, image.Rect(10, 20, 30, 25)), image.Rect(10, 20, 30, 25), true},
+		{newTestOutline(100, 50, image.Rect(10, 20, 11, 21), image.Rect(80, 5, 81, 6)), image.Rect(10, 5, 81, 21), true},
+		{newTestOutline(100, 50, image.Rect(0, 0, 100, 50)), image.Rect(0, 0, 100, 50), true},
+	}
+
+	for i, test := range tests {
+
+		r, ok := InkBounds(test.im)
+
+		if ok != test.ok {
+			t.Errorf("Expected ok to be %t for test %d", test.ok, i)
+			continue
+		}
+
+		if ok && !r.Eq(test.expected) {
+			t.Errorf("Expected bounds %v for test %d, got %v", test.expected, i, r)
+		}
+	}
+
+	// Transparent pixels are never inked
+
+	im := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+
+	_, ok := InkBounds(im)
+
+	if ok {
+		t.Errorf("Expected transparent image to have no ink")
+	}
+}
+
+func TestCropToContent(t *testing.T) {
+
+	tests := []struct {
+		im       image.Image
+		padding  int
+		expected image.Rectangle
+	}{
+		{newTestOutline(100, 50), 10, image.Rect(0, 0, 100, 50)},
+		{newTestOutline(100, 50, image.Rect(40, 20, 60, 30)), 0, image.Rect(0, 0, 20, 10)},
+		{newTestOutline(100, 50, image.Rect(40, 20, 60, 30)), 5, image.Rect(0, 0, 30, 20)},
+		{newTestOutline(100, 50, image.Rect(40, 20, 60, 30)), -5, image.Rect(0, 0, 20, 10)},
+		// Padding is clipped to the image
+		{newTestOutline(100, 50, image.Rect(2, 3, 60, 30)), 10, image.Rect(0, 0, 70, 40)},
+		{newTestOutline(100, 50, image.Rect(0, 0, 100, 50)), 10, image.Rect(0, 0, 100, 50)},
+	}
+
+	for i, test := range tests {
+
+		cropped := CropToContent(test.im, test.padding)
+
+		if !cropped.Bounds().Eq(test.expected) {
+			t.Errorf("Expected bounds %v for test %d, got %v", test.expected, i, cropped.Bounds())
+		}
+	}
+
+	// The cropped image contains the ink
+
+	im := newTestOutline(100, 50, image.Rect(40, 20, 60, 30))
+	cropped := CropToContent(im, 1)
+
+	r, ok := InkBounds(cropped)
+
+	if !ok || !r.Eq(image.Rect(1, 1, 21, 11)) {
+		t.Errorf("Unexpected ink bounds for cropped image, %v", r)
+	}
+}
+
+func TestEnlargeToPrintableArea(t *testing.T) {
+
+	max_w, max_h := PrintableArea("L")
+	full_w := int(max_w * SHEET_DPI)
+	full_h := int(max_h * SHEET_DPI)
+
+	tests := []struct {
+		name     string
+		w        int
+		h        int
+		enlarged bool
+	}{
+		{"small landscape", 200, 100, true},
+		{"small portrait", 100, 200, true},
+		{"full width", full_w, 100, false},
+		{"larger than page", full_w * 2, full_h * 2, false},
+	}
+
+	for _, test := range tests {
+
+		im := newTestOutline(test.w, test.h)
+		new_im := EnlargeToPrintableArea(im)
+
+		if !test.enlarged {
+
+			if new_im != image.Image(im) {
+				t.Fatalf("Expected %s image to be returned unchanged", test.name)
+			}
+
+			continue
+		}
+
+		bounds := new_im.Bounds()
+
+		if bounds.Dx() <= test.w || bounds.Dy() <= test.h {
+			t.Fatalf("Expected %s image to be enlarged, got %v", test.name, bounds)
+		}
+
+		if Orientation(new_im) != Orientation(im) {
+			t.Fatalf("Expected %s image to keep its orientation", test.name)
+		}
+
+		area_w, area_h := PrintableArea(Orientation(new_im))
+		w := float64(bounds.Dx()) / SHEET_DPI
+		h := float64(bounds.Dy()) / SHEET_DPI
+
+		if w > area_w || h > area_h {
+			t.Fatalf("Expected %s image to fit the printable area, got %v", test.name, bounds)
+		}
+
+		// One dimension should fill the printable area, to within a pixel
+
+		if area_w-w > 1/SHEET_DPI && area_h-h > 1/SHEET_DPI {
+			t.Fatalf("Expected %s image to fill the printable area, got %v", test.name, bounds)
+		}
+
+		if PrintedPixelsPerInch(new_im) != SHEET_DPI {
+			t.Fatalf("Expected %s image to be printed at %f pixels per inch", test.name, SHEET_DPI)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package coloringbook
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/whosonfirst/go-whosonfirst-uri"
+)
+
+// DefaultFilename returns the default filename for the PDF sheet for 'object_id' derived from 'image_id'.
+func DefaultFilename(object_id int64, image_id int64) string {
+	return fmt.Sprintf("%d-%d-coloringbook.pdf", object_id, image_id)
+}
+
+// VariantFilename returns 'filename' with the name of the difficulty variant 'variant' inserted before its
+// "-coloringbook" suffix (or its extension if there is no suffix). If 'variant' is empty 'filename' is returned unchanged.
+func VariantFilename(filename string, variant string) string {
+
+	if variant == "" {
+		return filename
+	}
+
+	ext := filepath.Ext(filename)
+	stem := strings.TrimSuffix(filename, ext)
+
+	if strings.HasSuffix(stem, "-coloringbook") {
+		stem = strings.TrimSuffix(stem, "-coloringbook")
+		return fmt.Sprintf("%s-%s-coloringbook%s", stem, variant, ext)
+	}
+
+	return fmt.Sprintf("%s-%s%s", stem, variant, ext)
+}
+
+// ThumbnailFilename returns the filename of the thumbnail image published alongside the PDF sheet 'filename'.
+func ThumbnailFilename(filename string) string {
+	return strings.Replace(filename, ".pdf", ".png", 1)
+}
+
+// FilenamePrefix returns the prefix shared by all the files published for 'object_id'.
+func FilenamePrefix(object_id int64) string {
+	return fmt.Sprintf("%d-", object_id)
+}
+
+// AppendTree returns 'filename' prefixed by the Who's On First -style tree (for example "151/173/222/3/")
+// derived from 'object_id'.
+func AppendTree(object_id int64, filename string) (string, error) {
+
+	tree, err := uri.Id2Path(object_id)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to derive tree for object id %d, %w", object_id, err)
+	}
+
+	return filepath.Join(tree, filename), nil
+}
+
+// ParseFilename parses 'key' (which may be prefixed by a path or Who's On First -style tree) and returns the
+// object ID, image ID and file extension encoded in it. An error is returned if 'key' was not produced by
+// DefaultFilename (or ThumbnailFilename), optionally followed by VariantFilename.
+func ParseFilename(key string) (int64, int64, string, error) {
+
+	fname := filepath.Base(key)
+	ext := filepath.Ext(fname)
+
+	stem := strings.TrimSuffix(fname, ext)
+
+	if !strings.HasSuffix(stem, "-coloringbook") {
+		return 0, 0, "", fmt.Errorf("Filename is missing -coloringbook suffix")
+	}
+
+	stem = strings.TrimSuffix(stem, "-coloringbook")
+	parts := strings.Split(stem, "-")
+
+	// Any parts after the object and image IDs are the name of a difficulty variant
+
+	if len(parts) < 2 {
+		return 0, 0, "", fmt.Errorf("Invalid filename")
+	}
+
+	object_id, err := strconv.ParseInt(parts[0], 10, 64)
+
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("Invalid object ID, %w", err)
+	}
+
+	image_id, err := strconv.ParseInt(parts[1], 10, 64)
+
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("Invalid image ID, %w", err)
+	}
+
+	return object_id, image_id, strings.TrimPrefix(ext, "."), nil
+}
@@ -0,0 +1,74 @@
+package coloringbook
+
+import (
+	"testing"
+)
+
+func TestParseFilename(t *testing.T) {
+
+	tests := []struct {
+		key       string
+		object_id int64
+		image_id  int64
+		ext       string
+		ok        bool
+	}{
+		{"123-456-coloringbook.pdf", 123, 456, "pdf", true},
+		{"123-456-coloringbook.png", 123, 456, "png", true},
+		{"123-456-easy-coloringbook.json", 123, 456, "json", true},
+		{"123/4/123-456-coloringbook.pdf", 123, 456, "pdf", true},
+		{DefaultFilename(1511214277, 1880244721), 1511214277, 1880244721, "pdf", true},
+		{VariantFilename(DefaultFilename(1, 2), "hard"), 1, 2, "pdf", true},
+		{ThumbnailFilename(DefaultFilename(1, 2)), 1, 2, "png", true},
+		{"123-456.pdf", 0, 0, "", false},
+		{"123-coloringbook.pdf", 0, 0, "", false},
+		{"abc-456-coloringbook.pdf", 0, 0, "", false},
+		{"123-def-coloringbook.pdf", 0, 0, "", false},
+		{"custom.pdf", 0, 0, "", false},
+	}
+
+	for _, test := range tests {
+
+		object_id, image_id, ext, err := ParseFilename(test.key)
+
+		if !test.ok {
+
+			if err == nil {
+				t.Errorf("Expected '%s' to fail", test.key)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Failed to parse '%s', %v", test.key, err)
+			continue
+		}
+
+		if object_id != test.object_id || image_id != test.image_id || ext != test.ext {
+			t.Errorf("Unexpected values for '%s', %d %d %s", test.key, object_id, image_id, ext)
+		}
+	}
+}
+
+func TestVariantFilename(t *testing.T) {
+
+	tests := []struct {
+		filename string
+		variant  string
+		expected string
+	}{
+		{"123-456-coloringbook.pdf", "", "123-456-coloringbook.pdf"},
+		{"123-456-coloringbook.pdf", "easy", "123-456-easy-coloringbook.pdf"},
+		{"custom.pdf", "easy", "custom-easy.pdf"},
+	}
+
+	for _, test := range tests {
+
+		v := VariantFilename(test.filename, test.variant)
+
+		if v != test.expected {
+			t.Errorf("Expected '%s' for %s (%s), got '%s'", test.expected, test.filename, test.variant, v)
+		}
+	}
+}
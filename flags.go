@@ -0,0 +1,249 @@
+package coloringbook
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/sfomuseum/go-coloringbook/outline"
+)
+
+// DEFAULT_PATH_BATIK is the default path to the Batik rasterizer JAR file.
+const DEFAULT_PATH_BATIK string = "/usr/local/src/batik-1.17/batik-rasterizer-1.17.jar"
+
+// OutlineFlags contains the values of the command line flags used to configure outline options.
+type OutlineFlags struct {
+	ContourIterations int
+	ContourScale      float64
+	ContourFormat     string
+	VtracerPrecision  int
+	VtracerSpeckle    int
+	UseBatik          bool
+	PathBatik         string
+	Preset            string
+	PresetsPath       string
+	OutlinerURI       string
+	Preprocess        string
+	SimplifyTolerance float64
+	SimplifySmooth    bool
+	SimplifyMinLength float64
+	AlphaBackground   string
+	AlphaEdgeWidth    int
+	MaxPixels         int
+}
+
+// AppendOutlineFlags appends the command line flags used to configure outline options to 'fs'.
+func AppendOutlineFlags(fs *flag.FlagSet) *OutlineFlags {
+
+	f := &OutlineFlags{}
+	defaults := DefaultOutlineOptions()
+
+	fs.IntVar(&f.ContourIterations, "contour-iteration", defaults.Contour.Iterations, "The number of contour lines to derive from a traced image. Must be at least 2.")
+	fs.Float64Var(&f.ContourScale, "contour-scale", defaults.Contour.Scale, "The scale factor to apply to contoured images.")
+	fs.StringVar(&f.ContourFormat, "contour-format", defaults.Contour.Format, "The format of contoured images. Valid options are: png, svg.")
+
+	fs.IntVar(&f.VtracerPrecision, "vtracer-precision", defaults.Trace.Precision, "The number of significant bits to use in an RGB channel when tracing images with vtracer.")
+	fs.IntVar(&f.VtracerSpeckle, "vtracer-speckle", defaults.Trace.Speckle, "Discard patches smaller than X pixels in size when tracing images with vtracer.")
+
+	fs.BoolVar(&f.UseBatik, "use-batik", defaults.Rasterize.UseBatik, "Use Batik to rasterize traced images. If false the (much slower) native rasterizer is used.")
+	fs.StringVar(&f.PathBatik, "path-batik", defaults.Rasterize.PathBatik, "The path to the Batik rasterizer JAR file.")
+
+	fs.StringVar(&f.OutlinerURI, "outliner-uri", DEFAULT_OUTLINER_URI, fmt.Sprintf("A URI used to create the backend for deriving outline images. Valid schemes are: %s.", strings.Join(OutlinerSchemes(), ", ")))
+
+	fs.StringVar(&f.Preprocess, "preprocess", "", "An optional comma-separated list of adjustments to apply to images before they are outlined, for example \"grayscale,normalize,blur:1.5,posterize:4\". Valid steps are: grayscale, normalize[:clip], blur[:sigma], denoise[:radius], posterize[:levels], levels:black:white[:gamma], isolate[:tolerance].")
+
+	fs.Float64Var(&f.SimplifyTolerance, "simplify-tolerance", DEFAULT_SIMPLIFY_TOLERANCE, "The maximum distance, in pixels, that simplified paths in SVG outlines may deviate from the original paths. If 0 paths are not simplified. Only applies to SVG outlines (-contour-format svg), which are simplified before they are written or embedded in sheets; raster outlines are never simplified.")
+	fs.BoolVar(&f.SimplifySmooth, "simplify-smooth", false, "Draw the paths in SVG outlines as smooth curves rather than straight line segments.")
+	fs.Float64Var(&f.SimplifyMinLength, "simplify-min-length", 0, "The minimum length, in pixels, of paths in SVG outlines. Shorter paths are removed.")
+
+	fs.StringVar(&f.AlphaBackground, "alpha-background", DEFAULT_ALPHA_BACKGROUND, "The colour, as a hex value, to composite images with transparent pixels onto before they are outlined.")
+	fs.IntVar(&f.AlphaEdgeWidth, "alpha-edge-width", 0, "If greater than 0 draw the edge of a transparent image's alpha channel, with this line width in pixels, as the subject's outer outline. Only applies to raster outlines.")
+
+	fs.IntVar(&f.MaxPixels, "max-pixels", DEFAULT_MAX_PIXELS, "The maximum number of pixels (width x height) in a source image. Larger images are refused before they are decoded. If 0 there is no limit.")
+
+	fs.StringVar(&f.Preset, "preset", "", "The name of an optional preset whose values will replace the contour, trace and rasterize flags. Default presets are: fine-line, bold-kids, technical-drawing, easy, medium, hard.")
+	fs.StringVar(&f.PresetsPath, "presets", "", "The path to an optional JSON or YAML (.yaml or .yml) file defining additional named presets.")
+
+	return f
+}
+
+// OutlineOptions returns a new `outline.OutlineOptions` instance derived from the flag values, without any preset applied.
+func (f *OutlineFlags) OutlineOptions() *outline.OutlineOptions {
+
+	contour_opts := &outline.ContourOptions{
+		Iterations: f.ContourIterations,
+		Scale:      f.ContourScale,
+		Format:     f.ContourFormat,
+	}
+
+	trace_opts := &outline.TraceOptions{
+		Precision: f.VtracerPrecision,
+		Speckle:   f.VtracerSpeckle,
+	}
+
+	raster_opts := &outline.RasterizeOptions{
+		UseBatik:  f.UseBatik,
+		PathBatik: f.PathBatik,
+	}
+
+	opts := &outline.OutlineOptions{
+		Contour:   contour_opts,
+		Trace:     trace_opts,
+		Rasterize: raster_opts,
+	}
+
+	return opts
+}
+
+// Presets returns the default presets combined with any presets defined in the file specified by the "-presets" flag.
+func (f *OutlineFlags) Presets() (Presets, error) {
+	return LoadPresets(f.PresetsPath)
+}
+
+// Outliner returns a new `Outliner` instance derived from the "-outliner-uri" flag and logs the backend
+// it will use with the default outline options, after checking which external tools are available.
+func (f *OutlineFlags) Outliner(ctx context.Context) (Outliner, error) {
+
+	o, err := NewOutliner(ctx, f.OutlinerURI)
+
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Using %s outliner backend\n", o.Backend(f.OutlineOptions()))
+	return o, nil
+}
+
+// SimplifyOptions returns the `SimplifyOptions` instance derived from the "-simplify-" flags, or nil if SVG outlines should not be simplified.
+func (f *OutlineFlags) SimplifyOptions() *SimplifyOptions {
+
+	if f.SimplifyTolerance <= 0 && !f.SimplifySmooth && f.SimplifyMinLength <= 0 {
+		return nil
+	}
+
+	opts := &SimplifyOptions{
+		Tolerance: f.SimplifyTolerance,
+		Smooth:    f.SimplifySmooth,
+		MinLength: f.SimplifyMinLength,
+	}
+
+	return opts
+}
+
+// AlphaOptions returns the `AlphaOptions` instance derived from the "-alpha-" flags.
+func (f *OutlineFlags) AlphaOptions() (*AlphaOptions, error) {
+
+	bg, err := ParseColor(f.AlphaBackground)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse -alpha-background flag, %w", err)
+	}
+
+	opts := &AlphaOptions{
+		Background: bg,
+		EdgeWidth:  f.AlphaEdgeWidth,
+	}
+
+	return opts, nil
+}
+
+// DecodeImageOptions returns the `DecodeImageOptions` instance derived from the "-max-pixels" flag.
+func (f *OutlineFlags) DecodeImageOptions() *DecodeImageOptions {
+
+	opts := &DecodeImageOptions{
+		MaxPixels: f.MaxPixels,
+	}
+
+	return opts
+}
+
+// PreprocessSteps returns the list of `PreprocessStep` instances derived from the "-preprocess" flag.
+func (f *OutlineFlags) PreprocessSteps() ([]*PreprocessStep, error) {
+	return ParsePreprocessSteps(f.Preprocess)
+}
+
+// PrintFlags contains the values of the command line flags used to configure print options.
+type PrintFlags struct {
+	LineWeight    string
+	MinRegionSize string
+}
+
+// AppendPrintFlags appends the command line flags used to configure print options to 'fs'.
+func AppendPrintFlags(fs *flag.FlagSet) *PrintFlags {
+
+	f := &PrintFlags{}
+
+	fs.StringVar(&f.LineWeight, "line-weight", "", "An optional width for outline lines on the printed sheet, with units, for example \"1pt\" or \"0.35mm\". Valid units are: pt, mm, cm, in. If empty line weights are left unchanged.")
+	fs.StringVar(&f.MinRegionSize, "min-region-size", "", "An optional minimum size for enclosed (colorable) regions on the printed sheet, expressed as the side of a square of equivalent area with units, for example \"4mm\". Smaller regions are merged with their neighbours. If empty regions are left unchanged.")
+
+	return f
+}
+
+// PrintOptions returns a new `PrintOptions` instance derived from the flag values, or nil if no print flags were set.
+func (f *PrintFlags) PrintOptions() (*PrintOptions, error) {
+
+	if f.LineWeight == "" && f.MinRegionSize == "" {
+		return nil, nil
+	}
+
+	opts := &PrintOptions{}
+
+	if f.LineWeight != "" {
+
+		v, err := ParseLength(f.LineWeight)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse -line-weight flag, %w", err)
+		}
+
+		opts.LineWeight = v
+	}
+
+	if f.MinRegionSize != "" {
+
+		v, err := ParseLength(f.MinRegionSize)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse -min-region-size flag, %w", err)
+		}
+
+		opts.MinRegionArea = v * v
+	}
+
+	return opts, nil
+}
+
+// QualityFlags contains the values of the command line flags used to configure outline quality checks.
+type QualityFlags struct {
+	QualityTarget    string
+	AutoTune         bool
+	AutoTuneAttempts int
+	RejectQuality    bool
+}
+
+// AppendQualityFlags appends the command line flags used to configure outline quality checks to 'fs'.
+func AppendQualityFlags(fs *flag.FlagSet) *QualityFlags {
+
+	f := &QualityFlags{}
+
+	fs.StringVar(&f.QualityTarget, "quality-target", DEFAULT_QUALITY_TARGET, "A comma-separated list of metric=min:max ranges defining acceptable outline metrics. Either min or max may be empty. Valid metrics are: ink-coverage, regions, average-region-size, line-density. Sheets outside the range are flagged in their manifest. If empty metrics are recorded but not checked.")
+	fs.BoolVar(&f.AutoTune, "auto-tune", false, "Adjust the contour iterations and vtracer speckle and precision values until outline metrics fall inside -quality-target.")
+	fs.IntVar(&f.AutoTuneAttempts, "auto-tune-attempts", DEFAULT_AUTOTUNE_ATTEMPTS, "The maximum number of outlines to derive when -auto-tune is enabled.")
+	fs.BoolVar(&f.RejectQuality, "reject-quality", false, "Reject (do not publish) sheets whose outline metrics fall outside -quality-target, rather than flagging them.")
+
+	return f
+}
+
+// Target returns the `QualityTarget` derived from the "-quality-target" flag.
+func (f *QualityFlags) Target() (*QualityTarget, error) {
+
+	t, err := ParseQualityTarget(f.QualityTarget)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse -quality-target flag, %w", err)
+	}
+
+	return t, nil
+}
@@ -4,22 +4,42 @@ import (
 	"context"
 	"fmt"
 	"image"
+	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
 	"log"
 	"net/http"
-	"os"
+	"time"
 
 	"github.com/jtacoma/uritemplates"
 	"github.com/sfomuseum/go-coloringbook/outline"
 	"github.com/tidwall/gjson"
 	"github.com/whosonfirst/go-reader"
 	wof_reader "github.com/whosonfirst/go-whosonfirst-reader"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
 )
 
 type DeriveObjectImageOptions struct {
 	Reader  reader.Reader
 	Outline *outline.OutlineOptions
+	// The Outliner used to derive outline images. If nil the default (vtracer) outliner is used.
+	Outliner Outliner
+	// An optional list of adjustments to apply to the image before it is outlined.
+	Preprocess []*PreprocessStep
+	// Optional configuration for automatically tuning the outline options. If nil the outline options are used as-is.
+	AutoTune *AutoTuneOptions
+	// Optional configuration for simplifying the paths in SVG outlines. If nil SVG outlines are left unchanged. Raster
+	// (PNG) outlines are never simplified.
+	Simplify *SimplifyOptions
+	// Optional configuration for handling images with an alpha channel. If nil transparent images are composited onto white.
+	Alpha *AlphaOptions
+	// Optional configuration for decoding images. If nil images with more than DEFAULT_MAX_PIXELS pixels are refused.
+	Decode *DecodeImageOptions
+	// A boolean flag indicating that images should be downscaled, using DownscaleForTrace, to the resolution needed for the
+	// page layout before they are outlined.
+	Downscale bool
 }
 
 func Orientation(im image.Image) string {
@@ -36,18 +56,26 @@ func Orientation(im image.Image) string {
 	return "L"
 }
 
-func DeriveObjectImage(ctx context.Context, opts *DeriveObjectImageOptions, image_id int64) (string, error) {
+// FetchObjectImage retrieves and decodes the original ("o") rendition of the image with ID 'image_id'
+// whose record is read from 'r'.
+func FetchObjectImage(ctx context.Context, r reader.Reader, image_id int64) (image.Image, error) {
+	return FetchObjectImageWithOptions(ctx, r, image_id, nil)
+}
+
+// FetchObjectImageWithOptions retrieves the original ("o") rendition of the image with ID 'image_id' whose record
+// is read from 'r' and decodes it using DecodeImageWithOptions and 'opts'.
+func FetchObjectImageWithOptions(ctx context.Context, r reader.Reader, image_id int64, opts *DecodeImageOptions) (image.Image, error) {
 
-	im_body, err := wof_reader.LoadBytes(ctx, opts.Reader, image_id)
+	im_body, err := wof_reader.LoadBytes(ctx, r, image_id)
 
 	if err != nil {
-		return "", fmt.Errorf("Failed to load body for image %d, %v", image_id, err)
+		return nil, fmt.Errorf("Failed to load body for image %d, %v", image_id, err)
 	}
 
 	o_rsp := gjson.GetBytes(im_body, "properties.media:properties.sizes.o")
 
 	if !o_rsp.Exists() {
-		return "", fmt.Errorf("Image %d is missing properties.media:properties.sizes.o property")
+		return nil, fmt.Errorf("Image %d is missing properties.media:properties.sizes.o property", image_id)
 	}
 
 	ext_rsp := o_rsp.Get("extension")
@@ -56,13 +84,13 @@ func DeriveObjectImage(ctx context.Context, opts *DeriveObjectImageOptions, imag
 	template_rsp := gjson.GetBytes(im_body, "properties.media:uri_template")
 
 	if !template_rsp.Exists() {
-		return "", fmt.Errorf("Image %d is missing properties.media:uri_template property")
+		return nil, fmt.Errorf("Image %d is missing properties.media:uri_template property", image_id)
 	}
 
 	uri_template, err := uritemplates.Parse(template_rsp.String())
 
 	if err != nil {
-		return "", fmt.Errorf("Failed to create URI template, %v", err)
+		return nil, fmt.Errorf("Failed to create URI template, %v", err)
 	}
 
 	template_values := map[string]interface{}{
@@ -74,52 +102,158 @@ func DeriveObjectImage(ctx context.Context, opts *DeriveObjectImageOptions, imag
 	im_uri, err := uri_template.Expand(template_values)
 
 	if err != nil {
-		return "", fmt.Errorf("Failed to expand URI template, %v", err)
+		return nil, fmt.Errorf("Failed to expand URI template, %v", err)
 	}
 
 	im_rsp, err := http.Get(im_uri)
 
 	if err != nil {
-		return "", fmt.Errorf("Failed to retrieve %s, %w", im_uri, err)
+		return nil, fmt.Errorf("Failed to retrieve %s, %w", im_uri, err)
 	}
 
 	defer im_rsp.Body.Close()
 
-	im, _, err := image.Decode(im_rsp.Body)
+	im, _, err := DecodeImageWithOptions(im_rsp.Body, opts)
 
 	if err != nil {
-		return "", fmt.Errorf("Failed to decode image %d (%s), %v", image_id, im_uri, err)
+		return nil, fmt.Errorf("Failed to decode image %d (%s), %v", image_id, im_uri, err)
 	}
 
-	log.Println("Generate outline")
+	return im, nil
+}
+
+// DeriveObjectImage derives an outline for the image with ID 'image_id', using DeriveObjectOutline, and returns it as a
+// (decoded) image. It will fail if the outliner produces vector (SVG) outlines.
+func DeriveObjectImage(ctx context.Context, opts *DeriveObjectImageOptions, image_id int64) (image.Image, error) {
 
-	contoured_im, err := outline.GenerateOutline(ctx, im, opts.Outline)
+	contoured_im, _, err := DeriveObjectOutline(ctx, opts, image_id)
 
 	if err != nil {
-		return "", fmt.Errorf("Failed to generate outline for image %d, %w", image_id, err)
+		return nil, err
 	}
 
-	im_tmpfile, err := os.CreateTemp("", "*.png")
+	im, err := OutlineImage(ctx, contoured_im)
 
 	if err != nil {
-		return "", fmt.Errorf("Failed to create outline file, %v", err)
+		return nil, fmt.Errorf("Failed to derive image for outline of image %d, %w", image_id, err)
 	}
 
-	object_image := im_tmpfile.Name()
+	return im, nil
+}
+
+// DeriveObjectOutline derives an outline for the image with ID 'image_id'. It returns the outline and the outline options
+// used to derive it, which will differ from those in 'opts' if auto-tuning is enabled.
+func DeriveObjectOutline(ctx context.Context, opts *DeriveObjectImageOptions, image_id int64) (outline.Outline, *outline.OutlineOptions, error) {
 
-	err = contoured_im.Write(ctx, im_tmpfile)
+	im, err := FetchObjectImageWithOptions(ctx, opts.Reader, image_id, opts.Decode)
 
 	if err != nil {
-		os.Remove(object_image)
-		return "", fmt.Errorf("Failed to encode outline file, %v", err)
+		return nil, nil, err
+	}
+
+	// The ratio of the number of pixels in the original image to the number of pixels outlined
+	downscale_ratio := 1.0
+
+	if opts.Downscale {
+
+		contour_scale := 1.0
+
+		if opts.Outline != nil && opts.Outline.Contour != nil {
+			contour_scale = opts.Outline.Contour.Scale
+		}
+
+		orig_size := im.Bounds().Size()
+		im = DownscaleForTrace(im, contour_scale)
+		new_size := im.Bounds().Size()
+
+		if new_size != orig_size {
+			downscale_ratio = float64(orig_size.X*orig_size.Y) / float64(new_size.X*new_size.Y)
+			log.Printf("Downscaled image %d from %dx%d to %dx%d before outlining\n", image_id, orig_size.X, orig_size.Y, new_size.X, new_size.Y)
+		}
+	}
+
+	t1 := time.Now()
+
+	im, alpha_mask := FlattenObjectImage(im, opts.Alpha)
+
+	if alpha_mask != nil {
+		log.Printf("Flattened transparent image %d\n", image_id)
+	}
+
+	if len(opts.Preprocess) > 0 {
+
+		log.Printf("Preprocess image (%s)\n", FormatPreprocessSteps(opts.Preprocess))
+
+		im, err = Preprocess(ctx, im, opts.Preprocess)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to preprocess image %d, %w", image_id, err)
+		}
 	}
 
-	err = im_tmpfile.Close()
+	outliner := opts.Outliner
+
+	if outliner == nil {
+
+		outliner, err = NewOutliner(ctx, DEFAULT_OUTLINER_URI)
+
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	log.Printf("Generate outline using %s backend\n", outliner.Backend(opts.Outline))
+
+	var contoured_im outline.Outline
+	contoured_opts := opts.Outline
+
+	if opts.AutoTune != nil {
+
+		rsp, err := AutoTune(ctx, outliner, im, opts.Outline, opts.AutoTune)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to auto-tune outline for image %d, %w", image_id, err)
+		}
+
+		log.Printf("Auto-tuned outline for image %d after %d attempts (%s)\n", image_id, rsp.Attempts, autoTuneKey(rsp.Options))
+
+		contoured_im = rsp.Outline
+		contoured_opts = rsp.Options
+
+	} else {
+
+		contoured_im, err = outliner.Outline(ctx, im, opts.Outline)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to generate outline for image %d, %w", image_id, err)
+		}
+	}
+
+	if downscale_ratio > 1.0 {
+
+		// Outlining time is roughly proportional to the number of pixels so estimate
+		// how long the full-size image would have taken to process.
+
+		elapsed := time.Since(t1)
+		saved := time.Duration(float64(elapsed) * (downscale_ratio - 1.0))
+
+		log.Printf("Outlined image %d in %v, an estimated %v faster than outlining the full-size image\n", image_id, elapsed.Round(time.Millisecond), saved.Round(time.Millisecond))
+	}
+
+	contoured_im, err = AddAlphaEdge(ctx, contoured_im, alpha_mask, opts.Alpha)
 
 	if err != nil {
-		os.Remove(object_image)
-		return "", fmt.Errorf("Failed to close outline file, %v", err)
+		return nil, nil, fmt.Errorf("Failed to draw alpha edge for image %d, %w", image_id, err)
+	}
+
+	if opts.Simplify != nil {
+
+		contoured_im, err = SimplifyOutline(ctx, contoured_im, opts.Simplify)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to simplify outline for image %d, %w", image_id, err)
+		}
 	}
 
-	return object_image, nil
+	return contoured_im, contoured_opts, nil
 }
@@ -0,0 +1,236 @@
+package coloringbook
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sort"
+)
+
+// DEFAULT_ISOLATE_TOLERANCE is the default maximum (RGB) distance from the estimated background colour for a pixel
+// to be considered part of the background.
+const DEFAULT_ISOLATE_TOLERANCE float64 = 32.0
+
+// minimum fraction of border pixels which must match the estimated background colour for the background to be masked.
+const isolate_min_border_fraction float64 = 0.5
+
+// EstimateBackground returns the median colour of the pixels along the border of 'im' and the fraction of
+// border pixels within 'tolerance' of that colour.
+func EstimateBackground(im image.Image, tolerance float64) (color.RGBA, float64) {
+
+	rgba := toRGBA(im)
+	return estimateBackground(rgba, tolerance)
+}
+
+// ForegroundMask returns a mask for 'im' where background pixels are black and foreground pixels are white. The
+// background is estimated from the colour of the pixels along the border of 'im' and includes all the pixels within
+// 'tolerance' of that colour connected to the border. If the border is not a (mostly) uniform colour the entire
+// image is considered foreground.
+func ForegroundMask(im image.Image, tolerance float64) *image.Gray {
+
+	rgba := toRGBA(im)
+	return foregroundMask(rgba, tolerance)
+}
+
+// IsolateSubject returns a copy of 'im' with the background, as defined by ForegroundMask, replaced by white pixels
+// and the mask itself.
+func IsolateSubject(im image.Image, tolerance float64) (image.Image, *image.Gray) {
+
+	rgba := toRGBA(im)
+
+	if rgba == im {
+		copy_rgba := image.NewRGBA(rgba.Rect)
+		copy(copy_rgba.Pix, rgba.Pix)
+		rgba = copy_rgba
+	}
+
+	mask := isolateSubject(rgba, tolerance)
+	return rgba, mask
+}
+
+func toRGBA(im image.Image) *image.RGBA {
+
+	rgba, ok := im.(*image.RGBA)
+
+	if ok && rgba.Rect.Min.X == 0 && rgba.Rect.Min.Y == 0 && rgba.Stride == rgba.Rect.Dx()*4 {
+		return rgba
+	}
+
+	bounds := im.Bounds()
+
+	rgba = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), im, bounds.Min, draw.Src)
+
+	return rgba
+}
+
+// isolateSubject replaces the background pixels of 'im' with white pixels, in place, and returns the foreground mask.
+func isolateSubject(im *image.RGBA, tolerance float64) *image.Gray {
+
+	mask := foregroundMask(im, tolerance)
+
+	for i, v := range mask.Pix {
+
+		if v != 0 {
+			continue
+		}
+
+		im.Pix[i*4] = 0xff
+		im.Pix[i*4+1] = 0xff
+		im.Pix[i*4+2] = 0xff
+		im.Pix[i*4+3] = 0xff
+	}
+
+	return mask
+}
+
+func foregroundMask(im *image.RGBA, tolerance float64) *image.Gray {
+
+	w := im.Rect.Dx()
+	h := im.Rect.Dy()
+
+	mask := image.NewGray(image.Rect(0, 0, w, h))
+
+	for i := range mask.Pix {
+		mask.Pix[i] = 0xff
+	}
+
+	if w == 0 || h == 0 {
+		return mask
+	}
+
+	bg, fraction := estimateBackground(im, tolerance)
+
+	if fraction < isolate_min_border_fraction {
+		return mask
+	}
+
+	is_background := func(i int) bool {
+		return colorDistance(im.Pix[i*4:i*4+3], bg) <= tolerance
+	}
+
+	queue := make([]int, 0)
+
+	for _, i := range borderIndices(w, h) {
+
+		if mask.Pix[i] != 0 && is_background(i) {
+			mask.Pix[i] = 0
+			queue = append(queue, i)
+		}
+	}
+
+	for len(queue) > 0 {
+
+		i := queue[0]
+		queue = queue[1:]
+
+		x := i % w
+		y := i / w
+
+		neighbours := [][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}}
+
+		for _, n := range neighbours {
+
+			nx := n[0]
+			ny := n[1]
+
+			if nx < 0 || ny < 0 || nx >= w || ny >= h {
+				continue
+			}
+
+			j := ny*w + nx
+
+			if mask.Pix[j] != 0 && is_background(j) {
+				mask.Pix[j] = 0
+				queue = append(queue, j)
+			}
+		}
+	}
+
+	return mask
+}
+
+func estimateBackground(im *image.RGBA, tolerance float64) (color.RGBA, float64) {
+
+	w := im.Rect.Dx()
+	h := im.Rect.Dy()
+
+	indices := borderIndices(w, h)
+
+	if len(indices) == 0 {
+		return color.RGBA{0xff, 0xff, 0xff, 0xff}, 0
+	}
+
+	channels := make([][]int, 3)
+
+	for c := 0; c < 3; c++ {
+
+		channels[c] = make([]int, len(indices))
+
+		for j, i := range indices {
+			channels[c][j] = int(im.Pix[i*4+c])
+		}
+
+		sort.Ints(channels[c])
+	}
+
+	mid := len(indices) / 2
+
+	bg := color.RGBA{
+		R: uint8(channels[0][mid]),
+		G: uint8(channels[1][mid]),
+		B: uint8(channels[2][mid]),
+		A: 0xff,
+	}
+
+	matches := 0
+
+	for _, i := range indices {
+
+		if colorDistance(im.Pix[i*4:i*4+3], bg) <= tolerance {
+			matches += 1
+		}
+	}
+
+	return bg, float64(matches) / float64(len(indices))
+}
+
+// borderIndices returns the (row-major) indices of the pixels along the border of a 'w' x 'h' image.
+func borderIndices(w int, h int) []int {
+
+	indices := make([]int, 0)
+
+	if w == 0 || h == 0 {
+		return indices
+	}
+
+	for x := 0; x < w; x++ {
+
+		indices = append(indices, x)
+
+		if h > 1 {
+			indices = append(indices, (h-1)*w+x)
+		}
+	}
+
+	for y := 1; y < h-1; y++ {
+
+		indices = append(indices, y*w)
+
+		if w > 1 {
+			indices = append(indices, y*w+w-1)
+		}
+	}
+
+	return indices
+}
+
+func colorDistance(px []uint8, c color.RGBA) float64 {
+
+	dr := float64(px[0]) - float64(c.R)
+	dg := float64(px[1]) - float64(c.G)
+	db := float64(px[2]) - float64(c.B)
+
+	return math.Sqrt(dr*dr + dg*dg + db*db)
+}
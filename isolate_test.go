@@ -0,0 +1,215 @@
+package coloringbook
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+// newTestSubject returns a 'w' x 'h' image filled with 'background' and a black square subject inset by 'inset' pixels.
+func newTestSubject(w int, h int, inset int, background color.Color) *image.RGBA {
+
+	im := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(im, im.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(im, image.Rect(inset, inset, w-inset, h-inset), image.NewUniform(color.Black), image.Point{}, draw.Src)
+
+	return im
+}
+
+// newNoisyTestSubject returns a 'w' x 'h' image of random pixels.
+func newNoisyTestSubject(w int, h int) *image.RGBA {
+
+	r := rand.New(rand.NewSource(1))
+	im := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for i := 0; i < len(im.Pix); i += 4 {
+		im.Pix[i] = uint8(r.Intn(256))
+		im.Pix[i+1] = uint8(r.Intn(256))
+		im.Pix[i+2] = uint8(r.Intn(256))
+		im.Pix[i+3] = 0xff
+	}
+
+	return im
+}
+
+func TestEstimateBackground(t *testing.T) {
+
+	tests := []struct {
+		im       image.Image
+		expected color.RGBA
+		min      float64
+		max      float64
+	}{
+		{newTestSubject(40, 30, 10, color.RGBA{0x20, 0x80, 0xc0, 0xff}), color.RGBA{0x20, 0x80, 0xc0, 0xff}, 1.0, 1.0},
+		{newTestSubject(40, 30, 10, color.White), color.RGBA{0xff, 0xff, 0xff, 0xff}, 1.0, 1.0},
+		// The subject touches the border so all the border pixels are black
+		{newTestSubject(40, 30, 0, color.White), color.RGBA{0x00, 0x00, 0x00, 0xff}, 1.0, 1.0},
+		{newNoisyTestSubject(40, 30), color.RGBA{}, 0.0, 0.25},
+	}
+
+	for i, test := range tests {
+
+		bg, fraction := EstimateBackground(test.im, DEFAULT_ISOLATE_TOLERANCE)
+
+		if fraction < test.min || fraction > test.max {
+			t.Errorf("Expected fraction between %f and %f for test %d, got %f", test.min, test.max, i, fraction)
+		}
+
+		if test.min == 1.0 && bg != test.expected {
+			t.Errorf("Expected background %v for test %d, got %v", test.expected, i, bg)
+		}
+	}
+}
+
+func TestForegroundMask(t *testing.T) {
+
+	count := func(mask *image.Gray) int {
+
+		n := 0
+
+		for _, v := range mask.Pix {
+
+			if v != 0 {
+				n += 1
+			}
+		}
+
+		return n
+	}
+
+	tests := []struct {
+		name       string
+		im         image.Image
+		foreground int
+	}{
+		{"uniform border", newTestSubject(40, 30, 10, color.White), 20 * 10},
+		{"coloured border", newTestSubject(40, 30, 5, color.RGBA{0x20, 0x80, 0xc0, 0xff}), 30 * 20},
+		{"noisy border", newNoisyTestSubject(40, 30), 40 * 30},
+	}
+
+	for _, test := range tests {
+
+		mask := ForegroundMask(test.im, DEFAULT_ISOLATE_TOLERANCE)
+
+		if !mask.Rect.Eq(test.im.Bounds()) {
+			t.Errorf("Unexpected mask bounds for %s, %v", test.name, mask.Rect)
+			continue
+		}
+
+		n := count(mask)
+
+		if n != test.foreground {
+			t.Errorf("Expected %d foreground pixels for %s, got %d", test.foreground, test.name, n)
+		}
+	}
+
+	// Pixels matching the background colour which are enclosed by the subject are not masked
+
+	im := newTestSubject(40, 30, 5, color.White)
+	draw.Draw(im, image.Rect(15, 12, 25, 18), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	mask := ForegroundMask(im, DEFAULT_ISOLATE_TOLERANCE)
+
+	if mask.GrayAt(20, 15).Y == 0 {
+		t.Errorf("Expected enclosed pixels to be foreground")
+	}
+
+	if mask.GrayAt(0, 0).Y != 0 {
+		t.Errorf("Expected border pixels to be background")
+	}
+}
+
+func TestIsolateSubject(t *testing.T) {
+
+	bg := color.RGBA{0x20, 0x80, 0xc0, 0xff}
+	im := newTestSubject(40, 30, 10, bg)
+
+	isolated, mask := IsolateSubject(im, DEFAULT_ISOLATE_TOLERANCE)
+
+	if mask == nil {
+		t.Fatalf("Expected mask")
+	}
+
+	tests := []struct {
+		x        int
+		y        int
+		expected color.RGBA
+	}{
+		{0, 0, color.RGBA{0xff, 0xff, 0xff, 0xff}},
+		{39, 29, color.RGBA{0xff, 0xff, 0xff, 0xff}},
+		{20, 15, color.RGBA{0x00, 0x00, 0x00, 0xff}},
+	}
+
+	for _, test := range tests {
+
+		c := color.RGBAModel.Convert(isolated.At(test.x, test.y)).(color.RGBA)
+
+		if c != test.expected {
+			t.Errorf("Expected %v at %d,%d, got %v", test.expected, test.x, test.y, c)
+		}
+	}
+
+	// The original image is not modified
+
+	if im.RGBAAt(0, 0) != bg {
+		t.Errorf("Expected original image to be unchanged")
+	}
+}
+
+func TestPreprocessWithMask(t *testing.T) {
+
+	im := newTestSubject(40, 30, 10, color.RGBA{0x20, 0x80, 0xc0, 0xff})
+
+	tests := []struct {
+		spec     string
+		has_mask bool
+	}{
+		{"", false},
+		{"grayscale", false},
+		{"isolate", true},
+		{"grayscale,isolate:16,blur:1", true},
+	}
+
+	for _, test := range tests {
+
+		steps, err := ParsePreprocessSteps(test.spec)
+
+		if err != nil {
+			t.Errorf("Failed to parse '%s', %v", test.spec, err)
+			continue
+		}
+
+		new_im, mask, err := PreprocessWithMask(context.Background(), im, steps)
+
+		if err != nil {
+			t.Errorf("Failed to preprocess '%s', %v", test.spec, err)
+			continue
+		}
+
+		if !new_im.Bounds().Eq(im.Bounds()) {
+			t.Errorf("Unexpected bounds for '%s', %v", test.spec, new_im.Bounds())
+		}
+
+		if (mask != nil) != test.has_mask {
+			t.Errorf("Expected mask to be %t for '%s'", test.has_mask, test.spec)
+		}
+
+		if mask != nil && mask.GrayAt(0, 0).Y != 0 {
+			t.Errorf("Expected border to be masked for '%s'", test.spec)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	steps, _ := ParsePreprocessSteps("isolate")
+
+	_, _, err := PreprocessWithMask(ctx, im, steps)
+
+	if err == nil {
+		t.Errorf("Expected cancelled context to fail")
+	}
+}
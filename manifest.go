@@ -0,0 +1,231 @@
+package coloringbook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"gocloud.dev/blob"
+)
+
+const (
+	// MANIFEST_STATUS_PUBLISHED indicates that a sheet was published directly, without review.
+	MANIFEST_STATUS_PUBLISHED string = "published"
+	// MANIFEST_STATUS_PENDING indicates that a sheet has been written to a staging bucket and is awaiting review.
+	MANIFEST_STATUS_PENDING string = "pending"
+	// MANIFEST_STATUS_APPROVED indicates that a staged sheet has been approved and copied to the public bucket.
+	MANIFEST_STATUS_APPROVED string = "approved"
+	// MANIFEST_STATUS_REJECTED indicates that a staged sheet has been rejected.
+	MANIFEST_STATUS_REJECTED string = "rejected"
+)
+
+// Manifest describes the files produced for a coloring book sheet. It is written alongside the PDF file
+// and thumbnail image using the filename returned by ManifestFilename.
+type Manifest struct {
+	ObjectId int64 `json:"object_id"`
+	ImageId  int64 `json:"image_id"`
+	// The bucket key of the PDF file.
+	PDF string `json:"pdf"`
+	// The bucket key of the thumbnail image.
+	Thumbnail string `json:"thumbnail"`
+	// The options used to derive the outline image, if an outline image was derived.
+	Outline *OutlineProperty `json:"outline,omitempty"`
+	// The backend (for example "vtracer+batik" or "native") used to derive the outline image, if an outline image was derived.
+	Backend string `json:"backend,omitempty"`
+	// The preprocess steps (as parsed by ParsePreprocessSteps) applied to the image before it was outlined, if any.
+	Preprocess string `json:"preprocess,omitempty"`
+	// The line weight and minimum region size adjustments applied to the outline image before it was laid out, if any.
+	Print *PrintOptions `json:"print,omitempty"`
+	// The metrics of the outline image, before any cropping or print adjustments were applied.
+	Metrics *OutlineMetrics `json:"metrics,omitempty"`
+	// The outline metrics which fell outside the acceptable range when the sheet was generated, if any.
+	QualityIssues []string `json:"quality_issues,omitempty"`
+	PageSize      string   `json:"page_size"`
+	Orientation   string   `json:"orientation"`
+	// The name of the difficulty variant (for example "easy") of the sheet, if any.
+	Variant string `json:"variant,omitempty"`
+	// The names of all the difficulty variants generated alongside the sheet, in the order they were defined.
+	Variants []string `json:"variants,omitempty"`
+	// The Unix timestamp when the sheet was generated. Difficulty variants generated together share the same timestamp.
+	Created int64 `json:"created"`
+	// The review status of the sheet. One of the MANIFEST_STATUS_ constants.
+	Status string `json:"status"`
+	// The Unix timestamp when the sheet was approved or rejected.
+	Reviewed int64 `json:"reviewed,omitempty"`
+}
+
+// ManifestFilename returns the filename of the manifest published alongside the PDF sheet 'filename'.
+func ManifestFilename(filename string) string {
+	return strings.Replace(filename, ".pdf", ".json", 1)
+}
+
+// ColoringBookProperty returns a new `ColoringBookProperty` instance derived from 'm'. If 'public_root_uri'
+// is not empty it will be prepended to the keys of the PDF file and thumbnail image.
+func (m *Manifest) ColoringBookProperty(public_root_uri string) *ColoringBookProperty {
+
+	public_uri := func(key string) string {
+
+		if public_root_uri == "" {
+			return key
+		}
+
+		return strings.TrimRight(public_root_uri, "/") + "/" + key
+	}
+
+	p := &ColoringBookProperty{
+		PDF:         public_uri(m.PDF),
+		Thumbnail:   public_uri(m.Thumbnail),
+		ImageId:     m.ImageId,
+		Outline:     m.Outline,
+		PageSize:    m.PageSize,
+		Orientation: m.Orientation,
+		Variant:     m.Variant,
+		Created:     m.Created,
+	}
+
+	return p
+}
+
+// ManifestsColoringBookProperty returns a new `ColoringBookProperty` instance describing the difficulty variants in
+// 'manifests'. The first manifest is used for the top-level properties and, if it is a variant, all the manifests
+// are listed in the property's Variants field.
+func ManifestsColoringBookProperty(manifests []*Manifest, public_root_uri string) *ColoringBookProperty {
+
+	if len(manifests) == 0 {
+		return nil
+	}
+
+	p := manifests[0].ColoringBookProperty(public_root_uri)
+
+	if p.Variant == "" {
+		return p
+	}
+
+	p.Variants = make([]*ColoringBookProperty, len(manifests))
+
+	for i, m := range manifests {
+		p.Variants[i] = m.ColoringBookProperty(public_root_uri)
+	}
+
+	return p
+}
+
+// LatestManifests returns the manifests in 'manifests' generated alongside the most recently created one,
+// ordered by difficulty variant.
+func LatestManifests(manifests []*Manifest) []*Manifest {
+
+	if len(manifests) == 0 {
+		return manifests
+	}
+
+	latest := manifests[0]
+
+	for _, m := range manifests {
+
+		if m.Created > latest.Created {
+			latest = m
+		}
+	}
+
+	if latest.Variant == "" {
+		return []*Manifest{latest}
+	}
+
+	siblings := make([]*Manifest, 0)
+
+	for _, v := range latest.Variants {
+
+		for _, m := range manifests {
+
+			if m.Created == latest.Created && m.Variant == v {
+				siblings = append(siblings, m)
+				break
+			}
+		}
+	}
+
+	return siblings
+}
+
+// ReadManifest reads and decodes the manifest stored at 'key' in 'bucket'.
+func ReadManifest(ctx context.Context, bucket *blob.Bucket, key string) (*Manifest, error) {
+
+	r, err := bucket.NewReader(ctx, key, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s for reading, %w", key, err)
+	}
+
+	defer r.Close()
+
+	var m *Manifest
+
+	dec := json.NewDecoder(r)
+	err = dec.Decode(&m)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode %s, %w", key, err)
+	}
+
+	return m, nil
+}
+
+// WriteManifest encodes 'm' and writes it to 'key' in 'bucket' using the attributes defined in 'opts'.
+func WriteManifest(ctx context.Context, bucket *blob.Bucket, key string, opts *PublishOptions, m *Manifest) error {
+
+	wr, err := NewPublishWriter(ctx, bucket, key, "application/json", opts, m.ObjectId, m.ImageId)
+
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(wr)
+	err = enc.Encode(m)
+
+	if err != nil {
+		return fmt.Errorf("Failed to encode %s, %w", key, err)
+	}
+
+	err = wr.Close()
+
+	if err != nil {
+		return fmt.Errorf("Failed to close %s, %w", key, err)
+	}
+
+	return nil
+}
+
+// copyFile copies 'key' from 'source' to 'target' using the attributes defined in 'opts'.
+func copyFile(ctx context.Context, source *blob.Bucket, target *blob.Bucket, key string, content_type string, opts *PublishOptions, object_id int64, image_id int64) error {
+
+	r, err := source.NewReader(ctx, key, nil)
+
+	if err != nil {
+		return fmt.Errorf("Failed to open %s for reading, %w", key, err)
+	}
+
+	defer r.Close()
+
+	wr, err := NewPublishWriter(ctx, target, key, content_type, opts, object_id, image_id)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(wr, r)
+
+	if err != nil {
+		wr.Close()
+		return fmt.Errorf("Failed to copy %s, %w", key, err)
+	}
+
+	err = wr.Close()
+
+	if err != nil {
+		return fmt.Errorf("Failed to close %s after copying, %w", key, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,206 @@
+package coloringbook
+
+import (
+	"fmt"
+
+	"github.com/sfomuseum/go-coloringbook/outline"
+	"github.com/tidwall/gjson"
+)
+
+// DefaultOutlineOptions returns a new `outline.OutlineOptions` instance with the default contour, trace and rasterize options.
+// These are also the defaults for the command line flags defined by AppendOutlineFlags.
+func DefaultOutlineOptions() *outline.OutlineOptions {
+
+	opts := &outline.OutlineOptions{
+		Contour: &outline.ContourOptions{
+			Iterations: 8,
+			Scale:      1.0,
+			Format:     "png",
+		},
+		Trace: &outline.TraceOptions{
+			Precision: 6,
+			Speckle:   8,
+		},
+		Rasterize: &outline.RasterizeOptions{
+			UseBatik:  true,
+			PathBatik: DEFAULT_PATH_BATIK,
+		},
+	}
+
+	return opts
+}
+
+// CloneOutlineOptions returns a (deep) copy of 'opts'.
+func CloneOutlineOptions(opts *outline.OutlineOptions) *outline.OutlineOptions {
+
+	if opts == nil {
+		return nil
+	}
+
+	clone := &outline.OutlineOptions{}
+
+	if opts.Contour != nil {
+		contour := *opts.Contour
+		clone.Contour = &contour
+	}
+
+	if opts.Trace != nil {
+		trace := *opts.Trace
+		clone.Trace = &trace
+	}
+
+	if opts.Rasterize != nil {
+		rasterize := *opts.Rasterize
+		clone.Rasterize = &rasterize
+	}
+
+	return clone
+}
+
+// completeOutlineOptions returns 'opts' if none of its contour, trace or rasterize options are nil. Otherwise it
+// returns a copy of 'opts' with the missing options taken from DefaultOutlineOptions, or the defaults if 'opts' is nil.
+func completeOutlineOptions(opts *outline.OutlineOptions) *outline.OutlineOptions {
+
+	if opts == nil {
+		return DefaultOutlineOptions()
+	}
+
+	if opts.Contour != nil && opts.Trace != nil && opts.Rasterize != nil {
+		return opts
+	}
+
+	defaults := DefaultOutlineOptions()
+	opts = CloneOutlineOptions(opts)
+
+	if opts.Contour == nil {
+		opts.Contour = defaults.Contour
+	}
+
+	if opts.Trace == nil {
+		opts.Trace = defaults.Trace
+	}
+
+	if opts.Rasterize == nil {
+		opts.Rasterize = defaults.Rasterize
+	}
+
+	return opts
+}
+
+// MergeOutlineOptions returns a copy of 'opts' with the values defined in 'overrides' applied. 'overrides' is
+// expected to be a JSON-encoded `OutlineProperty` instance; only the keys present in 'overrides' are applied.
+// The path to the Batik rasterizer can not be overridden.
+func MergeOutlineOptions(opts *outline.OutlineOptions, overrides []byte) (*outline.OutlineOptions, error) {
+
+	merged := CloneOutlineOptions(opts)
+
+	if merged == nil {
+		merged = &outline.OutlineOptions{}
+	}
+
+	if merged.Contour == nil {
+		merged.Contour = &outline.ContourOptions{}
+	}
+
+	if merged.Trace == nil {
+		merged.Trace = &outline.TraceOptions{}
+	}
+
+	if merged.Rasterize == nil {
+		merged.Rasterize = &outline.RasterizeOptions{}
+	}
+
+	if !gjson.ValidBytes(overrides) {
+		return nil, fmt.Errorf("Invalid JSON")
+	}
+
+	number := func(path string) (gjson.Result, bool, error) {
+
+		rsp := gjson.GetBytes(overrides, path)
+
+		if !rsp.Exists() {
+			return rsp, false, nil
+		}
+
+		if rsp.Type != gjson.Number {
+			return rsp, false, fmt.Errorf("Invalid value for %s, expected a number", path)
+		}
+
+		return rsp, true, nil
+	}
+
+	int_paths := map[string]*int{
+		"contour.iterations": &merged.Contour.Iterations,
+		"trace.precision":    &merged.Trace.Precision,
+		"trace.speckle":      &merged.Trace.Speckle,
+	}
+
+	for path, ptr := range int_paths {
+
+		rsp, ok, err := number(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			*ptr = int(rsp.Int())
+		}
+	}
+
+	scale_rsp, ok, err := number("contour.scale")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		merged.Contour.Scale = scale_rsp.Float()
+	}
+
+	format_rsp := gjson.GetBytes(overrides, "contour.format")
+
+	if format_rsp.Exists() {
+		merged.Contour.Format = format_rsp.String()
+	}
+
+	batik_rsp := gjson.GetBytes(overrides, "rasterize.use_batik")
+
+	if batik_rsp.Exists() {
+
+		if !batik_rsp.IsBool() {
+			return nil, fmt.Errorf("Invalid value for rasterize.use_batik, expected a boolean")
+		}
+
+		merged.Rasterize.UseBatik = batik_rsp.Bool()
+	}
+
+	if merged.Contour.Iterations < 2 {
+		return nil, fmt.Errorf("Invalid value for contour.iterations, must be at least 2")
+	}
+
+	if merged.Contour.Scale <= 0 {
+		return nil, fmt.Errorf("Invalid value for contour.scale, must be greater than 0")
+	}
+
+	return merged, nil
+}
+
+// ObjectOutlineOptions returns a copy of 'opts' with any per-object overrides, defined in the
+// "millsfield:coloring_book_options" property of the object record 'body', applied.
+func ObjectOutlineOptions(opts *outline.OutlineOptions, body []byte) (*outline.OutlineOptions, error) {
+
+	rsp := gjson.GetBytes(body, COLORING_BOOK_OPTIONS_PROPERTY)
+
+	if !rsp.Exists() {
+		return CloneOutlineOptions(opts), nil
+	}
+
+	merged, err := MergeOutlineOptions(opts, []byte(rsp.Raw))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to merge %s property, %w", COLORING_BOOK_OPTIONS_PROPERTY, err)
+	}
+
+	return merged, nil
+}
@@ -0,0 +1,71 @@
+package coloringbook
+
+import (
+	"testing"
+)
+
+func TestMergeOutlineOptions(t *testing.T) {
+
+	tests := []struct {
+		overrides string
+		ok        bool
+		expected  func(*testing.T, *OutlineProperty)
+	}{
+		{`{}`, true, func(t *testing.T, p *OutlineProperty) {
+
+			if p.Contour.Iterations != 8 || p.Trace.Speckle != 8 || !p.Rasterize.UseBatik {
+				t.Errorf("Expected defaults to be unchanged, %+v", p)
+			}
+		}},
+		{`{"contour":{"iterations":3,"scale":2.5,"format":"svg"}}`, true, func(t *testing.T, p *OutlineProperty) {
+
+			if p.Contour.Iterations != 3 || p.Contour.Scale != 2.5 || p.Contour.Format != "svg" {
+				t.Errorf("Expected contour overrides to be applied, %+v", p.Contour)
+			}
+
+			if p.Trace.Precision != 6 {
+				t.Errorf("Expected trace options to be unchanged, %+v", p.Trace)
+			}
+		}},
+		{`{"trace":{"speckle":20},"rasterize":{"use_batik":false}}`, true, func(t *testing.T, p *OutlineProperty) {
+
+			if p.Trace.Speckle != 20 || p.Trace.Precision != 6 || p.Rasterize.UseBatik {
+				t.Errorf("Expected trace and rasterize overrides to be applied, %+v %+v", p.Trace, p.Rasterize)
+			}
+		}},
+		{`{"contour":{"iterations":"3"}}`, false, nil},
+		{`{"rasterize":{"use_batik":"no"}}`, false, nil},
+		{`{"contour":`, false, nil},
+	}
+
+	for _, test := range tests {
+
+		defaults := DefaultOutlineOptions()
+
+		merged, err := MergeOutlineOptions(defaults, []byte(test.overrides))
+
+		if !test.ok {
+
+			if err == nil {
+				t.Errorf("Expected '%s' to fail", test.overrides)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Failed to merge '%s', %v", test.overrides, err)
+			continue
+		}
+
+		if merged.Rasterize.PathBatik != DEFAULT_PATH_BATIK {
+			t.Errorf("Expected Batik path to be preserved, got '%s'", merged.Rasterize.PathBatik)
+		}
+
+		if merged == defaults || merged.Contour == defaults.Contour {
+			t.Errorf("Expected merged options to be a copy")
+		}
+
+		test.expected(t, NewOutlineProperty(merged))
+	}
+}
@@ -0,0 +1,229 @@
+package coloringbook
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// exif_orientation_tag is the EXIF (TIFF) tag ID for the image orientation.
+const exif_orientation_tag uint16 = 0x0112
+
+// DecodeImage decodes the image in 'r' using DecodeImageWithOptions with the default options, refusing images with more than DEFAULT_MAX_PIXELS pixels.
+func DecodeImage(r io.Reader) (image.Image, string, error) {
+	return DecodeImageWithOptions(r, nil)
+}
+
+// DecodeImageWithOptions decodes the image in 'r' (JPEG, PNG, GIF, BMP, TIFF, including CMYK TIFF, or WebP), after checking its dimensions against
+// the pixel budget in 'opts', and converts it to an 8-bit colour model using NormalizeColorModel. JPEG images are also rotated
+// and flipped according to their EXIF Orientation tag so that the image is upright. It returns the image and the name of its
+// format. Only the first frame (or page) of animated GIF and multi-page TIFF images is decoded. If 'opts' is nil images with more
+// than DEFAULT_MAX_PIXELS pixels are refused.
+func DecodeImageWithOptions(r io.Reader, opts *DecodeImageOptions) (image.Image, string, error) {
+
+	body, err := io.ReadAll(r)
+
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to read image, %w", err)
+	}
+
+	max_pixels := DEFAULT_MAX_PIXELS
+
+	if opts != nil {
+		max_pixels = opts.MaxPixels
+	}
+
+	_, _, err = CheckPixelBudget(body, max_pixels)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	var im image.Image
+	var format string
+
+	// CMYK TIFF images are not supported by the golang.org/x/image/tiff package
+
+	if isCMYKTIFF(body) {
+		im, err = decodeCMYKTIFF(body)
+		format = "tiff"
+	} else {
+		im, format, err = image.Decode(bytes.NewReader(body))
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	im = NormalizeColorModel(im)
+
+	if format == "jpeg" {
+		im = ApplyOrientation(im, ExifOrientation(body))
+	}
+
+	return im, format, nil
+}
+
+// ExifOrientation returns the value (1-8) of the EXIF Orientation tag in the JPEG image 'body', or 1 (upright)
+// if 'body' is not a JPEG image or has no valid Orientation tag.
+func ExifOrientation(body []byte) int {
+
+	if len(body) < 4 || body[0] != 0xff || body[1] != 0xd8 {
+		return 1
+	}
+
+	offset := 2
+
+	for offset+4 <= len(body) {
+
+		if body[offset] != 0xff {
+			return 1
+		}
+
+		marker := body[offset+1]
+
+		// Skip fill bytes
+		if marker == 0xff {
+			offset += 1
+			continue
+		}
+
+		// Start of scan; there is no more metadata
+		if marker == 0xda || marker == 0xd9 {
+			return 1
+		}
+
+		length := int(binary.BigEndian.Uint16(body[offset+2 : offset+4]))
+
+		if length < 2 || offset+2+length > len(body) {
+			return 1
+		}
+
+		segment := body[offset+4 : offset+2+length]
+
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+
+		offset += 2 + length
+	}
+
+	return 1
+}
+
+// tiffOrientation returns the value of the Orientation tag in the first IFD of the TIFF data 'tiff', or 1.
+func tiffOrientation(tiff []byte) int {
+
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	if order.Uint16(tiff[2:4]) != 42 {
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+
+	count := int(order.Uint16(tiff[ifd : ifd+2]))
+
+	for i := 0; i < count; i++ {
+
+		entry := ifd + 2 + i*12
+
+		if entry+12 > len(tiff) {
+			return 1
+		}
+
+		if order.Uint16(tiff[entry:entry+2]) != exif_orientation_tag {
+			continue
+		}
+
+		// The value is a SHORT stored in the first two bytes of the value field
+		v := int(order.Uint16(tiff[entry+8 : entry+10]))
+
+		if v < 1 || v > 8 {
+			return 1
+		}
+
+		return v
+	}
+
+	return 1
+}
+
+// ApplyOrientation returns a copy of 'im' rotated and flipped so that an image with the EXIF orientation
+// 'orientation' (1-8) is upright. If 'orientation' is 1, or invalid, 'im' is returned unchanged.
+func ApplyOrientation(im image.Image, orientation int) image.Image {
+
+	if orientation < 2 || orientation > 8 {
+		return im
+	}
+
+	bounds := im.Bounds()
+
+	w := bounds.Dx()
+	h := bounds.Dy()
+
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), im, bounds.Min, draw.Src)
+
+	dw := w
+	dh := h
+
+	// Orientations 5-8 are rotated by 90 degrees
+	if orientation >= 5 {
+		dw = h
+		dh = w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+
+			var sx, sy int
+
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+
+			si := sy*src.Stride + sx*4
+			di := y*dst.Stride + x*4
+
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+
+	return dst
+}
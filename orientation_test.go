@@ -0,0 +1,200 @@
+package coloringbook
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+var (
+	testRed    = color.NRGBA{0xff, 0x00, 0x00, 0xff}
+	testGreen  = color.NRGBA{0x00, 0xff, 0x00, 0xff}
+	testBlue   = color.NRGBA{0x00, 0x00, 0xff, 0xff}
+	testYellow = color.NRGBA{0xff, 0xff, 0x00, 0xff}
+)
+
+func TestApplyOrientation(t *testing.T) {
+
+	// The upright image is 64x32 pixels with red, green, blue and yellow top-left, top-right,
+	// bottom-left and bottom-right quadrants. Each test defines the quadrants of the image as stored.
+
+	tests := []struct {
+		orientation int
+		stored      [4]color.NRGBA
+	}{
+		{1, [4]color.NRGBA{testRed, testGreen, testBlue, testYellow}},
+		{2, [4]color.NRGBA{testGreen, testRed, testYellow, testBlue}},
+		{3, [4]color.NRGBA{testYellow, testBlue, testGreen, testRed}},
+		{4, [4]color.NRGBA{testBlue, testYellow, testRed, testGreen}},
+		{5, [4]color.NRGBA{testRed, testBlue, testGreen, testYellow}},
+		{6, [4]color.NRGBA{testGreen, testYellow, testRed, testBlue}},
+		{7, [4]color.NRGBA{testYellow, testGreen, testBlue, testRed}},
+		{8, [4]color.NRGBA{testBlue, testRed, testYellow, testGreen}},
+	}
+
+	for _, test := range tests {
+
+		w, h := 64, 32
+
+		if test.orientation >= 5 {
+			w, h = 32, 64
+		}
+
+		stored := newTestQuadrants(w, h, test.stored)
+		body := encodeTestJPEG(t, stored, test.orientation)
+
+		orientation := ExifOrientation(body)
+
+		if orientation != test.orientation {
+			t.Fatalf("Expected EXIF orientation %d, got %d", test.orientation, orientation)
+		}
+
+		im, format, err := DecodeImageWithOptions(bytes.NewReader(body), nil)
+
+		if err != nil {
+			t.Fatalf("Failed to decode image with orientation %d, %v", test.orientation, err)
+		}
+
+		if format != "jpeg" {
+			t.Fatalf("Expected format jpeg, got %s", format)
+		}
+
+		bounds := im.Bounds()
+
+		if bounds.Dx() != 64 || bounds.Dy() != 32 {
+			t.Fatalf("Expected 64x32 image for orientation %d, got %dx%d", test.orientation, bounds.Dx(), bounds.Dy())
+		}
+
+		corners := []struct {
+			point    image.Point
+			expected color.NRGBA
+		}{
+			{image.Pt(bounds.Min.X, bounds.Min.Y), testRed},
+			{image.Pt(bounds.Max.X-1, bounds.Min.Y), testGreen},
+			{image.Pt(bounds.Min.X, bounds.Max.Y-1), testBlue},
+			{image.Pt(bounds.Max.X-1, bounds.Max.Y-1), testYellow},
+		}
+
+		for _, c := range corners {
+
+			got := color.NRGBAModel.Convert(im.At(c.point.X, c.point.Y)).(color.NRGBA)
+
+			if !similarColor(got, c.expected) {
+				t.Fatalf("Expected %v at %v for orientation %d, got %v", c.expected, c.point, test.orientation, got)
+			}
+		}
+	}
+}
+
+func TestExifOrientationInvalid(t *testing.T) {
+
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{"empty", []byte{}},
+		{"not jpeg", []byte("GIF89a")},
+		{"no exif", []byte{0xff, 0xd8, 0xff, 0xd9}},
+		{"out of range", exifSegment(9)},
+	}
+
+	for _, test := range tests {
+
+		v := ExifOrientation(test.body)
+
+		if v != 1 {
+			t.Fatalf("Expected orientation 1 for %s, got %d", test.name, v)
+		}
+	}
+}
+
+// newTestQuadrants returns a 'w' x 'h' image whose top-left, top-right, bottom-left and bottom-right quadrants are 'colors'.
+func newTestQuadrants(w int, h int, colors [4]color.NRGBA) *image.NRGBA {
+
+	im := image.NewNRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+
+			i := 0
+
+			if x >= w/2 {
+				i += 1
+			}
+
+			if y >= h/2 {
+				i += 2
+			}
+
+			im.SetNRGBA(x, y, colors[i])
+		}
+	}
+
+	return im
+}
+
+// encodeTestJPEG returns 'im' encoded as a JPEG image with an EXIF segment whose Orientation tag is 'orientation'.
+func encodeTestJPEG(t *testing.T, im image.Image, orientation int) []byte {
+
+	var buf bytes.Buffer
+
+	err := jpeg.Encode(&buf, im, &jpeg.Options{Quality: 100})
+
+	if err != nil {
+		t.Fatalf("Failed to encode JPEG image, %v", err)
+	}
+
+	body := buf.Bytes()
+
+	// Insert the EXIF (APP1) segment immediately after the SOI marker
+
+	segment := exifSegment(orientation)[2:]
+
+	jpeg_body := make([]byte, 0, len(body)+len(segment))
+	jpeg_body = append(jpeg_body, body[0:2]...)
+	jpeg_body = append(jpeg_body, segment...)
+	jpeg_body = append(jpeg_body, body[2:]...)
+
+	return jpeg_body
+}
+
+// exifSegment returns an SOI marker followed by a big-endian EXIF (APP1) segment with an Orientation tag of 'orientation'.
+func exifSegment(orientation int) []byte {
+
+	order := binary.BigEndian
+
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8}
+	tiff = order.AppendUint16(tiff, 1)
+	tiff = order.AppendUint16(tiff, exif_orientation_tag)
+	tiff = order.AppendUint16(tiff, 3)
+	tiff = order.AppendUint32(tiff, 1)
+	tiff = order.AppendUint16(tiff, uint16(orientation))
+	tiff = order.AppendUint16(tiff, 0)
+	tiff = order.AppendUint32(tiff, 0)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+
+	segment := []byte{0xff, 0xd8, 0xff, 0xe1}
+	segment = order.AppendUint16(segment, uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	return segment
+}
+
+// similarColor returns true if each channel of 'a' and 'b' differs by less than 32, allowing for JPEG compression.
+func similarColor(a color.NRGBA, b color.NRGBA) bool {
+
+	diff := func(x uint8, y uint8) int {
+
+		if x > y {
+			return int(x - y)
+		}
+
+		return int(y - x)
+	}
+
+	return diff(a.R, b.R) < 32 && diff(a.G, b.G) < 32 && diff(a.B, b.B) < 32
+}
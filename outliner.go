@@ -0,0 +1,111 @@
+package coloringbook
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/aaronland/go-roster"
+	"github.com/sfomuseum/go-coloringbook/outline"
+)
+
+// DEFAULT_OUTLINER_URI is the URI of the default outliner, which uses vtracer and (optionally) Batik.
+const DEFAULT_OUTLINER_URI string = "vtracer://"
+
+var outliner_roster roster.Roster
+
+// OutlinerInitializationFunc is a function defined by individual outliner implementations and used to create
+// an instance of that outliner.
+type OutlinerInitializationFunc func(ctx context.Context, uri string) (Outliner, error)
+
+// Outliner is an interface for deriving outline (coloring book) images from source images.
+type Outliner interface {
+	// Outline derives an outline from 'im' using the options defined in 'opts'. Implementations may ignore
+	// any options which do not apply to them.
+	Outline(context.Context, image.Image, *outline.OutlineOptions) (outline.Outline, error)
+	// Name returns the name of the outliner.
+	Name() string
+	// Backend returns the name of the backend (for example "vtracer+batik") that will be used to derive
+	// outlines with 'opts', after accounting for any tools that are unavailable.
+	Backend(*outline.OutlineOptions) string
+}
+
+// RegisterOutliner registers 'scheme' as a key pointing to 'init_func' in an internal lookup table
+// used to create new `Outliner` instances by the `NewOutliner` method.
+func RegisterOutliner(ctx context.Context, scheme string, init_func OutlinerInitializationFunc) error {
+
+	err := ensureOutlinerRoster()
+
+	if err != nil {
+		return err
+	}
+
+	return outliner_roster.Register(ctx, scheme, init_func)
+}
+
+func ensureOutlinerRoster() error {
+
+	if outliner_roster == nil {
+
+		r, err := roster.NewDefaultRoster()
+
+		if err != nil {
+			return err
+		}
+
+		outliner_roster = r
+	}
+
+	return nil
+}
+
+// NewOutliner returns a new `Outliner` instance configured by 'uri'. The value of 'uri' is parsed
+// as a `url.URL` and its scheme is used as the key for a corresponding `OutlinerInitializationFunc`
+// function used to instantiate the new `Outliner`.
+func NewOutliner(ctx context.Context, uri string) (Outliner, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse outliner URI, %w", err)
+	}
+
+	err = ensureOutlinerRoster()
+
+	if err != nil {
+		return nil, err
+	}
+
+	i, err := outliner_roster.Driver(ctx, u.Scheme)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find outliner for '%s' scheme, %w", u.Scheme, err)
+	}
+
+	init_func := i.(OutlinerInitializationFunc)
+	return init_func(ctx, uri)
+}
+
+// OutlinerSchemes returns the list of outliner schemes that have been registered.
+func OutlinerSchemes() []string {
+
+	ctx := context.Background()
+	schemes := []string{}
+
+	err := ensureOutlinerRoster()
+
+	if err != nil {
+		return schemes
+	}
+
+	for _, dr := range outliner_roster.Drivers(ctx) {
+		scheme := fmt.Sprintf("%s://", strings.ToLower(dr))
+		schemes = append(schemes, scheme)
+	}
+
+	sort.Strings(schemes)
+	return schemes
+}
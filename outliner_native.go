@@ -0,0 +1,515 @@
+package coloringbook
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/nfnt/resize"
+	"github.com/sfomuseum/go-coloringbook/outline"
+)
+
+// NativeOutliner implements the `Outliner` interface using a pure-Go edge detection pipeline (smoothing, gradient,
+// thresholding and thinning) which does not depend on any external tools.
+type NativeOutliner struct {
+	// The standard deviation of the Gaussian blur applied before edge detection.
+	sigma float64
+	// The gradient magnitude, relative to the maximum gradient, below which pixels are never considered edges.
+	low float64
+	// The gradient magnitude, relative to the maximum gradient, above which pixels are always considered edges.
+	high float64
+	// The width, in pixels, of the lines drawn for edges.
+	line_width int
+}
+
+// imageOutline implements the `outline.Outline` interface for an `image.Image` instance.
+type imageOutline struct {
+	image image.Image
+}
+
+var _ Outliner = (*NativeOutliner)(nil)
+var _ outline.Outline = (*imageOutline)(nil)
+
+// Write encodes the outline as a PNG image to 'wr'.
+func (o *imageOutline) Write(ctx context.Context, wr io.Writer) error {
+	return png.Encode(wr, o.image)
+}
+
+func init() {
+	ctx := context.Background()
+	RegisterOutliner(ctx, "native", NewNativeOutliner)
+}
+
+// NewNativeOutliner returns a new `NativeOutliner` instance configured by 'uri' which is expected to take the form of:
+//
+//	native://?{PARAMETERS}
+//
+// Where {PARAMETERS} may be:
+// * `sigma` - The standard deviation of the Gaussian blur applied before edge detection. Default is 1.4.
+// * `low` - The relative gradient magnitude (0-1) below which pixels are never considered edges. Default is 0.08.
+// * `high` - The relative gradient magnitude (0-1) above which pixels are always considered edges. Default is 0.2.
+// * `line-width` - The width, in pixels, of the lines drawn for edges. Default is 2.
+func NewNativeOutliner(ctx context.Context, uri string) (Outliner, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	q := u.Query()
+
+	o := &NativeOutliner{
+		sigma:      1.4,
+		low:        0.08,
+		high:       0.2,
+		line_width: 2,
+	}
+
+	float_params := map[string]*float64{
+		"sigma": &o.sigma,
+		"low":   &o.low,
+		"high":  &o.high,
+	}
+
+	for k, ptr := range float_params {
+
+		if !q.Has(k) {
+			continue
+		}
+
+		v, err := strconv.ParseFloat(q.Get(k), 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ?%s= parameter, %w", k, err)
+		}
+
+		*ptr = v
+	}
+
+	if q.Has("line-width") {
+
+		v, err := strconv.Atoi(q.Get("line-width"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ?line-width= parameter, %w", err)
+		}
+
+		o.line_width = v
+	}
+
+	if o.sigma < 0 {
+		return nil, fmt.Errorf("Invalid ?sigma= parameter, must not be negative")
+	}
+
+	if o.low < 0 || o.high > 1 || o.low > o.high {
+		return nil, fmt.Errorf("Invalid ?low= or ?high= parameters, must satisfy 0 <= low <= high <= 1")
+	}
+
+	if o.line_width < 1 {
+		return nil, fmt.Errorf("Invalid ?line-width= parameter, must be at least 1")
+	}
+
+	return o, nil
+}
+
+// Outline derives an outline from 'im'. Only the Contour.Scale and Trace.Speckle options (connected edges
+// smaller than this many pixels are discarded) are used. Only PNG outlines are supported.
+func (o *NativeOutliner) Outline(ctx context.Context, im image.Image, opts *outline.OutlineOptions) (outline.Outline, error) {
+
+	scale := 1.0
+	speckle := 0
+
+	if opts != nil && opts.Contour != nil {
+
+		format := strings.ToLower(opts.Contour.Format)
+
+		if format != "" && format != "png" {
+			return nil, fmt.Errorf("The native outliner does not support '%s' outlines", opts.Contour.Format)
+		}
+
+		if opts.Contour.Scale > 0 {
+			scale = opts.Contour.Scale
+		}
+	}
+
+	if opts != nil && opts.Trace != nil {
+		speckle = opts.Trace.Speckle
+	}
+
+	bounds := im.Bounds()
+	w := bounds.Dx()
+	h := bounds.Dy()
+
+	if w < 3 || h < 3 {
+		return nil, fmt.Errorf("Image is too small to outline")
+	}
+
+	gray := grayscale(im)
+	gray = gaussianBlur(gray, w, h, o.sigma)
+
+	magnitude := sobel(gray, w, h)
+	edges := hysteresis(magnitude, w, h, o.low, o.high)
+
+	thin(edges, w, h)
+	removeSpeckles(edges, w, h, speckle)
+
+	new_im := drawEdges(edges, w, h, o.line_width)
+
+	if scale != 1.0 {
+		new_im = resize.Resize(uint(float64(w)*scale), uint(float64(h)*scale), new_im, resize.Bilinear)
+	}
+
+	return &imageOutline{image: new_im}, nil
+}
+
+// Name returns "native".
+func (o *NativeOutliner) Name() string {
+	return "native"
+}
+
+// Backend returns "native".
+func (o *NativeOutliner) Backend(opts *outline.OutlineOptions) string {
+	return "native"
+}
+
+// grayscale returns the luminance (0-255) of each pixel in 'im', in row-major order.
+func grayscale(im image.Image) []float64 {
+
+	bounds := im.Bounds()
+	w := bounds.Dx()
+	h := bounds.Dy()
+
+	gray := make([]float64, w*h)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.GrayModel.Convert(im.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray)
+			gray[y*w+x] = float64(c.Y)
+		}
+	}
+
+	return gray
+}
+
+// gaussianBlur applies a separable Gaussian blur with standard deviation 'sigma' to 'px'.
+func gaussianBlur(px []float64, w int, h int, sigma float64) []float64 {
+
+	if sigma == 0 {
+		return px
+	}
+
+	radius := int(math.Ceil(sigma * 3))
+	kernel := make([]float64, radius*2+1)
+	sum := 0.0
+
+	for i := -radius; i <= radius; i++ {
+		v := math.Exp(-float64(i*i) / (2 * sigma * sigma))
+		kernel[i+radius] = v
+		sum += v
+	}
+
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	clamp := func(v int, max int) int {
+
+		if v < 0 {
+			return 0
+		}
+
+		if v >= max {
+			return max - 1
+		}
+
+		return v
+	}
+
+	tmp := make([]float64, w*h)
+	out := make([]float64, w*h)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+
+			v := 0.0
+
+			for k := -radius; k <= radius; k++ {
+				v += px[y*w+clamp(x+k, w)] * kernel[k+radius]
+			}
+
+			tmp[y*w+x] = v
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+
+			v := 0.0
+
+			for k := -radius; k <= radius; k++ {
+				v += tmp[clamp(y+k, h)*w+x] * kernel[k+radius]
+			}
+
+			out[y*w+x] = v
+		}
+	}
+
+	return out
+}
+
+// sobel returns the gradient magnitude of each pixel in 'px', normalized to the range 0-1.
+func sobel(px []float64, w int, h int) []float64 {
+
+	magnitude := make([]float64, w*h)
+	max := 0.0
+
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+
+			at := func(dx int, dy int) float64 {
+				return px[(y+dy)*w+(x+dx)]
+			}
+
+			gx := -at(-1, -1) - 2*at(-1, 0) - at(-1, 1) + at(1, -1) + 2*at(1, 0) + at(1, 1)
+			gy := -at(-1, -1) - 2*at(0, -1) - at(1, -1) + at(-1, 1) + 2*at(0, 1) + at(1, 1)
+
+			m := math.Hypot(gx, gy)
+			magnitude[y*w+x] = m
+
+			if m > max {
+				max = m
+			}
+		}
+	}
+
+	if max > 0 {
+
+		for i := range magnitude {
+			magnitude[i] /= max
+		}
+	}
+
+	return magnitude
+}
+
+// hysteresis returns a binary edge map where pixels whose magnitude is above 'high', or above 'low' and
+// connected to a pixel above 'high', are considered edges.
+func hysteresis(magnitude []float64, w int, h int, low float64, high float64) []bool {
+
+	edges := make([]bool, w*h)
+	stack := make([]int, 0)
+
+	for i, m := range magnitude {
+
+		if m >= high && m > 0 {
+			edges[i] = true
+			stack = append(stack, i)
+		}
+	}
+
+	for len(stack) > 0 {
+
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		x := i % w
+		y := i / w
+
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+
+				nx := x + dx
+				ny := y + dy
+
+				if nx < 0 || ny < 0 || nx >= w || ny >= h {
+					continue
+				}
+
+				j := ny*w + nx
+
+				if !edges[j] && magnitude[j] >= low && magnitude[j] > 0 {
+					edges[j] = true
+					stack = append(stack, j)
+				}
+			}
+		}
+	}
+
+	return edges
+}
+
+// thin reduces the edges in 'edges' to single pixel wide lines, in place, using the Zhang-Suen thinning algorithm.
+func thin(edges []bool, w int, h int) {
+
+	neighbours := func(x int, y int) [8]bool {
+		return [8]bool{
+			edges[(y-1)*w+x],   // P2
+			edges[(y-1)*w+x+1], // P3
+			edges[y*w+x+1],     // P4
+			edges[(y+1)*w+x+1], // P5
+			edges[(y+1)*w+x],   // P6
+			edges[(y+1)*w+x-1], // P7
+			edges[y*w+x-1],     // P8
+			edges[(y-1)*w+x-1], // P9
+		}
+	}
+
+	for {
+
+		changed := false
+
+		for step := 0; step < 2; step++ {
+
+			to_remove := make([]int, 0)
+
+			for y := 1; y < h-1; y++ {
+				for x := 1; x < w-1; x++ {
+
+					if !edges[y*w+x] {
+						continue
+					}
+
+					p := neighbours(x, y)
+
+					count := 0
+					transitions := 0
+
+					for i := 0; i < 8; i++ {
+
+						if p[i] {
+							count += 1
+						}
+
+						if !p[i] && p[(i+1)%8] {
+							transitions += 1
+						}
+					}
+
+					if count < 2 || count > 6 || transitions != 1 {
+						continue
+					}
+
+					if step == 0 && (p[0] && p[2] && p[4] || p[2] && p[4] && p[6]) {
+						continue
+					}
+
+					if step == 1 && (p[0] && p[2] && p[6] || p[0] && p[4] && p[6]) {
+						continue
+					}
+
+					to_remove = append(to_remove, y*w+x)
+				}
+			}
+
+			for _, i := range to_remove {
+				edges[i] = false
+			}
+
+			if len(to_remove) > 0 {
+				changed = true
+			}
+		}
+
+		if !changed {
+			break
+		}
+	}
+}
+
+// removeSpeckles removes, in place, connected edges in 'edges' which contain fewer than 'speckle' pixels.
+func removeSpeckles(edges []bool, w int, h int, speckle int) {
+
+	if speckle <= 1 {
+		return
+	}
+
+	visited := make([]bool, w*h)
+
+	for start := range edges {
+
+		if !edges[start] || visited[start] {
+			continue
+		}
+
+		component := []int{start}
+		visited[start] = true
+
+		for i := 0; i < len(component); i++ {
+
+			x := component[i] % w
+			y := component[i] / w
+
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+
+					nx := x + dx
+					ny := y + dy
+
+					if nx < 0 || ny < 0 || nx >= w || ny >= h {
+						continue
+					}
+
+					j := ny*w + nx
+
+					if edges[j] && !visited[j] {
+						visited[j] = true
+						component = append(component, j)
+					}
+				}
+			}
+		}
+
+		if len(component) < speckle {
+
+			for _, i := range component {
+				edges[i] = false
+			}
+		}
+	}
+}
+
+// drawEdges returns a new image with black lines, 'line_width' pixels wide, drawn for 'edges' on a white background.
+func drawEdges(edges []bool, w int, h int, line_width int) image.Image {
+
+	im := image.NewGray(image.Rect(0, 0, w, h))
+
+	for i := range im.Pix {
+		im.Pix[i] = 0xff
+	}
+
+	offset := (line_width - 1) / 2
+
+	for i, is_edge := range edges {
+
+		if !is_edge {
+			continue
+		}
+
+		x := i%w - offset
+		y := i/w - offset
+
+		for dy := 0; dy < line_width; dy++ {
+			for dx := 0; dx < line_width; dx++ {
+
+				px := x + dx
+				py := y + dy
+
+				if px < 0 || py < 0 || px >= w || py >= h {
+					continue
+				}
+
+				im.Pix[py*im.Stride+px] = 0x00
+			}
+		}
+	}
+
+	return im
+}
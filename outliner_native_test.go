@@ -0,0 +1,144 @@
+package coloringbook
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestNativeOutliner(t *testing.T) {
+
+	ctx := context.Background()
+
+	o, err := NewOutliner(ctx, "native://?line-width=1")
+
+	if err != nil {
+		t.Fatalf("Failed to create native outliner, %v", err)
+	}
+
+	// A black square on a white background
+
+	im := image.NewGray(image.Rect(0, 0, 64, 64))
+	draw.Draw(im, im.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(im, image.Rect(16, 16, 48, 48), image.NewUniform(color.Black), image.Point{}, draw.Src)
+
+	o_outline, err := o.Outline(ctx, im, nil)
+
+	if err != nil {
+		t.Fatalf("Failed to outline image, %v", err)
+	}
+
+	outline_im, err := OutlineImage(ctx, o_outline)
+
+	if err != nil {
+		t.Fatalf("Failed to derive outline image, %v", err)
+	}
+
+	bounds := outline_im.Bounds()
+	w := bounds.Dx()
+	h := bounds.Dy()
+
+	if w != 64 || h != 64 {
+		t.Fatalf("Expected 64x64 outline, got %dx%d", w, h)
+	}
+
+	is_edge := func(x int, y int) bool {
+
+		if x < 0 || y < 0 || x >= w || y >= h {
+			return false
+		}
+
+		c := color.GrayModel.Convert(outline_im.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray)
+		return c.Y < 0x80
+	}
+
+	count := 0
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+
+			if !is_edge(x, y) {
+				continue
+			}
+
+			count += 1
+
+			// Every edge pixel must be connected to at least two others for the edge to be closed
+
+			neighbours := 0
+
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+
+					if (dx != 0 || dy != 0) && is_edge(x+dx, y+dy) {
+						neighbours += 1
+					}
+				}
+			}
+
+			if neighbours < 2 {
+				t.Fatalf("Edge pixel at (%d,%d) has %d neighbours, expected a closed edge", x, y, neighbours)
+			}
+
+			// A one pixel wide edge never contains a 2x2 block of edge pixels
+
+			if is_edge(x+1, y) && is_edge(x, y+1) && is_edge(x+1, y+1) {
+				t.Fatalf("Edge at (%d,%d) is more than one pixel wide", x, y)
+			}
+		}
+	}
+
+	if count == 0 {
+		t.Fatalf("Expected outline to contain edge pixels")
+	}
+
+	// The edge must separate the inside of the square from the border of the image
+
+	visited := make([]bool, w*h)
+	queue := []image.Point{{0, 0}}
+	visited[0] = true
+
+	for len(queue) > 0 {
+
+		pt := queue[0]
+		queue = queue[1:]
+
+		for _, d := range []image.Point{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+
+			n := pt.Add(d)
+
+			if n.X < 0 || n.Y < 0 || n.X >= w || n.Y >= h || visited[n.Y*w+n.X] || is_edge(n.X, n.Y) {
+				continue
+			}
+
+			visited[n.Y*w+n.X] = true
+			queue = append(queue, n)
+		}
+	}
+
+	if visited[32*w+32] {
+		t.Fatalf("Expected the outline of the square to be closed")
+	}
+}
+
+func TestNativeOutlinerFormat(t *testing.T) {
+
+	ctx := context.Background()
+
+	o, err := NewOutliner(ctx, "native://")
+
+	if err != nil {
+		t.Fatalf("Failed to create native outliner, %v", err)
+	}
+
+	opts := DefaultOutlineOptions()
+	opts.Contour.Format = "svg"
+
+	_, err = o.Outline(ctx, newTestOutline(16, 16), opts)
+
+	if err == nil {
+		t.Fatalf("Expected native outliner to refuse SVG outlines")
+	}
+}
@@ -0,0 +1,160 @@
+package coloringbook
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"net/url"
+	"sync"
+
+	"github.com/sfomuseum/go-coloringbook/outline"
+)
+
+// VtracerOutliner implements the `Outliner` interface using the sfomuseum/go-coloringbook/outline package
+// which traces images with the vtracer binary, rasterizes them with Batik (or natively) and then contours them.
+// The availability of vtracer and java is checked when the outliner is created. If Batik is unavailable traced
+// images are rasterized natively and if vtracer is unavailable outlines are derived using a fallback outliner.
+type VtracerOutliner struct {
+	// The error returned looking for the vtracer binary, if any.
+	vtracer_err error
+	// The outliner to use if vtracer is unavailable. This will be nil if fallbacks are disabled.
+	fallback Outliner
+	// A map of Batik rasterizer paths and the errors (or nil) returned looking for them.
+	batik_errors *sync.Map
+}
+
+var _ Outliner = (*VtracerOutliner)(nil)
+
+func init() {
+	ctx := context.Background()
+	RegisterOutliner(ctx, "vtracer", NewVtracerOutliner)
+}
+
+// NewVtracerOutliner returns a new `VtracerOutliner` instance configured by 'uri' which is expected to take the form of:
+//
+//	vtracer://?{PARAMETERS}
+//
+// Where {PARAMETERS} may be:
+// * `fallback` - The URI of the outliner to use if the vtracer binary is unavailable. Default is "native://". If "none" then no fallback is used.
+func NewVtracerOutliner(ctx context.Context, uri string) (Outliner, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	q := u.Query()
+
+	fallback_uri := "native://"
+
+	if q.Has("fallback") {
+		fallback_uri = q.Get("fallback")
+	}
+
+	_, vtracer_err := FindVtracer()
+
+	o := &VtracerOutliner{
+		vtracer_err:  vtracer_err,
+		batik_errors: new(sync.Map),
+	}
+
+	if vtracer_err != nil && fallback_uri != "none" && fallback_uri != "" {
+
+		fallback, err := NewOutliner(ctx, fallback_uri)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create fallback outliner, %w", err)
+		}
+
+		log.Printf("%v, falling back to %s outliner\n", vtracer_err, fallback.Name())
+		o.fallback = fallback
+	}
+
+	return o, nil
+}
+
+// Outline derives an outline from 'im' using all the options defined in 'opts'. If Batik is requested but
+// unavailable the (slower) native rasterizer is used instead. If 'opts', or any of its contour, trace or
+// rasterize options, are nil the values returned by DefaultOutlineOptions are used.
+func (o *VtracerOutliner) Outline(ctx context.Context, im image.Image, opts *outline.OutlineOptions) (outline.Outline, error) {
+
+	if o.vtracer_err != nil {
+
+		if o.fallback == nil {
+			return nil, o.vtracer_err
+		}
+
+		return o.fallback.Outline(ctx, im, opts)
+	}
+
+	opts = completeOutlineOptions(opts)
+
+	if opts.Rasterize != nil && opts.Rasterize.UseBatik {
+
+		err := o.findBatik(opts.Rasterize.PathBatik)
+
+		if err != nil {
+			opts = CloneOutlineOptions(opts)
+			opts.Rasterize.UseBatik = false
+		}
+	}
+
+	return outline.GenerateOutline(ctx, im, opts)
+}
+
+// Name returns "vtracer".
+func (o *VtracerOutliner) Name() string {
+	return "vtracer"
+}
+
+// Backend returns "vtracer+batik" or "vtracer+native" depending on how traced images will be rasterized
+// for 'opts' or the backend of the fallback outliner if vtracer is unavailable.
+func (o *VtracerOutliner) Backend(opts *outline.OutlineOptions) string {
+
+	if o.vtracer_err != nil {
+
+		if o.fallback == nil {
+			return "none"
+		}
+
+		return o.fallback.Backend(opts)
+	}
+
+	if opts != nil && opts.Rasterize != nil && opts.Rasterize.UseBatik {
+
+		if o.findBatik(opts.Rasterize.PathBatik) == nil {
+			return "vtracer+batik"
+		}
+	}
+
+	return "vtracer+native"
+}
+
+// findBatik returns the (cached) result of calling FindBatik for 'path_batik'. The first time an unavailable
+// path is encountered the fallback to the native rasterizer is logged.
+func (o *VtracerOutliner) findBatik(path_batik string) error {
+
+	v, exists := o.batik_errors.Load(path_batik)
+
+	if exists {
+
+		if v == nil {
+			return nil
+		}
+
+		return v.(error)
+	}
+
+	err := FindBatik(path_batik)
+
+	if err != nil {
+		log.Printf("%v, falling back to native rasterizer\n", err)
+		o.batik_errors.Store(path_batik, err)
+		return err
+	}
+
+	o.batik_errors.Store(path_batik, nil)
+	return nil
+}
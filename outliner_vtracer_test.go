@@ -0,0 +1,91 @@
+package coloringbook
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestVtracerOutlinerFallback(t *testing.T) {
+
+	ctx := context.Background()
+
+	// An empty PATH, so that vtracer is unavailable
+
+	t.Setenv("PATH", t.TempDir())
+
+	tests := []struct {
+		uri      string
+		backend  string
+		outlines bool
+	}{
+		{"vtracer://", "native", true},
+		{"vtracer://?fallback=native://", "native", true},
+		{"vtracer://?fallback=none", "none", false},
+	}
+
+	im := newTestOutline(32, 32)
+
+	for _, test := range tests {
+
+		o, err := NewOutliner(ctx, test.uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create outliner for %s, %v", test.uri, err)
+		}
+
+		backend := o.Backend(DefaultOutlineOptions())
+
+		if backend != test.backend {
+			t.Fatalf("Expected backend %s for %s, got %s", test.backend, test.uri, backend)
+		}
+
+		_, err = o.Outline(ctx, im, DefaultOutlineOptions())
+
+		if test.outlines && err != nil {
+			t.Fatalf("Expected %s to outline image using fallback, %v", test.uri, err)
+		}
+
+		if !test.outlines && err == nil {
+			t.Fatalf("Expected %s to fail without vtracer", test.uri)
+		}
+	}
+}
+
+func TestVtracerOutlinerAvailable(t *testing.T) {
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Test relies on a shell script standing in for vtracer")
+	}
+
+	ctx := context.Background()
+
+	// A PATH containing a (stand-in) vtracer binary but no java binary
+
+	root := t.TempDir()
+	path := filepath.Join(root, "vtracer")
+
+	err := os.WriteFile(path, []byte("#!/bin/sh\nexit 0\n"), 0755)
+
+	if err != nil {
+		t.Fatalf("Failed to write vtracer stub, %v", err)
+	}
+
+	t.Setenv("PATH", root)
+
+	o, err := NewOutliner(ctx, "vtracer://")
+
+	if err != nil {
+		t.Fatalf("Failed to create outliner, %v", err)
+	}
+
+	// Batik is requested by default but java is unavailable so the native rasterizer is used
+
+	backend := o.Backend(DefaultOutlineOptions())
+
+	if backend != "vtracer+native" {
+		t.Fatalf("Expected backend vtracer+native, got %s", backend)
+	}
+}
@@ -0,0 +1,394 @@
+package coloringbook
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PreprocessStep is a single image adjustment applied to an image before it is outlined.
+type PreprocessStep struct {
+	// The name of the step. One of: grayscale, normalize, blur, denoise, posterize, levels, isolate.
+	Name string
+	// The (numeric) arguments for the step.
+	Args []float64
+}
+
+// preprocessArgs defines the minimum and maximum number of arguments, and the default arguments, for each step.
+var preprocessArgs = map[string]struct {
+	min      int
+	max      int
+	defaults []float64
+}{
+	"grayscale": {0, 0, []float64{}},
+	"normalize": {0, 1, []float64{1.0}},
+	"blur":      {0, 1, []float64{1.0}},
+	"denoise":   {0, 1, []float64{1}},
+	"posterize": {0, 1, []float64{4}},
+	"levels":    {2, 3, []float64{0, 255, 1.0}},
+	"isolate":   {0, 1, []float64{DEFAULT_ISOLATE_TOLERANCE}},
+}
+
+// ParsePreprocessSteps parses 'spec', a comma-separated list of steps in the form "name" or "name:arg:arg", and returns
+// the corresponding `PreprocessStep` instances. Valid steps are:
+// * `grayscale` - Convert the image to grayscale.
+// * `normalize[:clip]` - Stretch the contrast of the image so that the darkest and lightest 'clip' percent of pixels (default 1) become black and white.
+// * `blur[:sigma]` - Apply a Gaussian blur with standard deviation 'sigma' (default 1.0).
+// * `denoise[:radius]` - Apply a median filter with radius 'radius' pixels (default 1).
+// * `posterize[:levels]` - Reduce each colour channel to 'levels' values (default 4).
+// * `levels:black:white[:gamma]` - Map the input range 'black'-'white' (0-255) to the full output range, with optional gamma correction.
+// * `isolate[:tolerance]` - Replace the background, estimated from the colour of the image border, with white (see ForegroundMask). Default tolerance is 32.
+func ParsePreprocessSteps(spec string) ([]*PreprocessStep, error) {
+
+	steps := make([]*PreprocessStep, 0)
+
+	for _, str_step := range strings.Split(spec, ",") {
+
+		str_step = strings.TrimSpace(str_step)
+
+		if str_step == "" {
+			continue
+		}
+
+		parts := strings.Split(str_step, ":")
+		name := strings.ToLower(parts[0])
+
+		def, exists := preprocessArgs[name]
+
+		if !exists {
+			return nil, fmt.Errorf("Invalid preprocess step '%s'", name)
+		}
+
+		str_args := parts[1:]
+
+		if len(str_args) < def.min || len(str_args) > def.max {
+			return nil, fmt.Errorf("Invalid number of arguments for preprocess step '%s'", name)
+		}
+
+		args := make([]float64, len(def.defaults))
+		copy(args, def.defaults)
+
+		for i, str_arg := range str_args {
+
+			v, err := strconv.ParseFloat(str_arg, 64)
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid argument for preprocess step '%s', %w", name, err)
+			}
+
+			args[i] = v
+		}
+
+		err := validatePreprocessArgs(name, args)
+
+		if err != nil {
+			return nil, err
+		}
+
+		steps = append(steps, &PreprocessStep{Name: name, Args: args})
+	}
+
+	return steps, nil
+}
+
+func validatePreprocessArgs(name string, args []float64) error {
+
+	switch name {
+	case "normalize":
+
+		if args[0] < 0 || args[0] >= 50 {
+			return fmt.Errorf("Invalid argument for preprocess step 'normalize', clip must be between 0 and 50")
+		}
+
+	case "blur":
+
+		if args[0] <= 0 {
+			return fmt.Errorf("Invalid argument for preprocess step 'blur', sigma must be greater than 0")
+		}
+
+	case "denoise":
+
+		if args[0] < 1 || args[0] != math.Trunc(args[0]) {
+			return fmt.Errorf("Invalid argument for preprocess step 'denoise', radius must be a positive integer")
+		}
+
+	case "posterize":
+
+		if args[0] < 2 || args[0] > 256 || args[0] != math.Trunc(args[0]) {
+			return fmt.Errorf("Invalid argument for preprocess step 'posterize', levels must be an integer between 2 and 256")
+		}
+
+	case "levels":
+
+		if args[0] < 0 || args[1] > 255 || args[0] >= args[1] {
+			return fmt.Errorf("Invalid arguments for preprocess step 'levels', must satisfy 0 <= black < white <= 255")
+		}
+
+		if args[2] <= 0 {
+			return fmt.Errorf("Invalid argument for preprocess step 'levels', gamma must be greater than 0")
+		}
+
+	case "isolate":
+
+		if args[0] < 0 {
+			return fmt.Errorf("Invalid argument for preprocess step 'isolate', tolerance must not be negative")
+		}
+	}
+
+	return nil
+}
+
+// String returns the string representation of 's' as parsed by ParsePreprocessSteps.
+func (s *PreprocessStep) String() string {
+
+	parts := []string{s.Name}
+
+	for _, a := range s.Args {
+		parts = append(parts, strconv.FormatFloat(a, 'f', -1, 64))
+	}
+
+	return strings.Join(parts, ":")
+}
+
+// FormatPreprocessSteps returns the string representation of 'steps' as parsed by ParsePreprocessSteps.
+func FormatPreprocessSteps(steps []*PreprocessStep) string {
+
+	str_steps := make([]string, len(steps))
+
+	for i, s := range steps {
+		str_steps[i] = s.String()
+	}
+
+	return strings.Join(str_steps, ",")
+}
+
+// Preprocess returns a copy of 'im' with each of 'steps' applied, in order. If 'steps' is empty 'im' is returned unchanged.
+func Preprocess(ctx context.Context, im image.Image, steps []*PreprocessStep) (image.Image, error) {
+
+	new_im, _, err := PreprocessWithMask(ctx, im, steps)
+	return new_im, err
+}
+
+// PreprocessWithMask returns a copy of 'im' with each of 'steps' applied, in order, and the foreground mask derived by the
+// last "isolate" step. If there is no "isolate" step the mask will be nil.
+func PreprocessWithMask(ctx context.Context, im image.Image, steps []*PreprocessStep) (image.Image, *image.Gray, error) {
+
+	if len(steps) == 0 {
+		return im, nil, nil
+	}
+
+	var mask *image.Gray
+
+	bounds := im.Bounds()
+
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), im, bounds.Min, draw.Src)
+
+	for _, s := range steps {
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		default:
+			// pass
+		}
+
+		switch s.Name {
+		case "grayscale":
+			preprocessGrayscale(rgba)
+		case "normalize":
+			preprocessNormalize(rgba, s.Args[0])
+		case "blur":
+			preprocessBlur(rgba, s.Args[0])
+		case "denoise":
+			preprocessDenoise(rgba, int(s.Args[0]))
+		case "posterize":
+			preprocessPosterize(rgba, int(s.Args[0]))
+		case "levels":
+			preprocessLevels(rgba, s.Args[0], s.Args[1], s.Args[2])
+		case "isolate":
+			mask = isolateSubject(rgba, s.Args[0])
+		default:
+			return nil, nil, fmt.Errorf("Invalid preprocess step '%s'", s.Name)
+		}
+	}
+
+	return rgba, mask, nil
+}
+
+// mapChannels replaces the R, G and B values of each pixel in 'im' using 'lookup'.
+func mapChannels(im *image.RGBA, lookup [256]uint8) {
+
+	for i := 0; i < len(im.Pix); i += 4 {
+		im.Pix[i] = lookup[im.Pix[i]]
+		im.Pix[i+1] = lookup[im.Pix[i+1]]
+		im.Pix[i+2] = lookup[im.Pix[i+2]]
+	}
+}
+
+func clampUint8(v float64) uint8 {
+
+	if v < 0 {
+		return 0
+	}
+
+	if v > 255 {
+		return 255
+	}
+
+	return uint8(math.Round(v))
+}
+
+func preprocessGrayscale(im *image.RGBA) {
+
+	for i := 0; i < len(im.Pix); i += 4 {
+		y := 0.299*float64(im.Pix[i]) + 0.587*float64(im.Pix[i+1]) + 0.114*float64(im.Pix[i+2])
+		v := clampUint8(y)
+		im.Pix[i] = v
+		im.Pix[i+1] = v
+		im.Pix[i+2] = v
+	}
+}
+
+func preprocessNormalize(im *image.RGBA, clip float64) {
+
+	var histogram [256]int
+	count := 0
+
+	for i := 0; i < len(im.Pix); i += 4 {
+		y := clampUint8(0.299*float64(im.Pix[i]) + 0.587*float64(im.Pix[i+1]) + 0.114*float64(im.Pix[i+2]))
+		histogram[y] += 1
+		count += 1
+	}
+
+	if count == 0 {
+		return
+	}
+
+	threshold := int(float64(count) * clip / 100.0)
+
+	low := 0
+	seen := 0
+
+	for low < 255 {
+
+		seen += histogram[low]
+
+		if seen > threshold {
+			break
+		}
+
+		low += 1
+	}
+
+	high := 255
+	seen = 0
+
+	for high > 0 {
+
+		seen += histogram[high]
+
+		if seen > threshold {
+			break
+		}
+
+		high -= 1
+	}
+
+	if high <= low {
+		return
+	}
+
+	preprocessLevels(im, float64(low), float64(high), 1.0)
+}
+
+func preprocessLevels(im *image.RGBA, black float64, white float64, gamma float64) {
+
+	var lookup [256]uint8
+
+	for i := 0; i < 256; i++ {
+
+		v := (float64(i) - black) / (white - black)
+		v = math.Max(0, math.Min(1, v))
+		v = math.Pow(v, 1.0/gamma)
+
+		lookup[i] = clampUint8(v * 255)
+	}
+
+	mapChannels(im, lookup)
+}
+
+func preprocessPosterize(im *image.RGBA, levels int) {
+
+	var lookup [256]uint8
+	step := 255.0 / float64(levels-1)
+
+	for i := 0; i < 256; i++ {
+		lookup[i] = clampUint8(math.Round(float64(i)/step) * step)
+	}
+
+	mapChannels(im, lookup)
+}
+
+func preprocessBlur(im *image.RGBA, sigma float64) {
+
+	w := im.Rect.Dx()
+	h := im.Rect.Dy()
+
+	channel := make([]float64, w*h)
+
+	for c := 0; c < 3; c++ {
+
+		for i := range channel {
+			channel[i] = float64(im.Pix[i*4+c])
+		}
+
+		blurred := gaussianBlur(channel, w, h, sigma)
+
+		for i, v := range blurred {
+			im.Pix[i*4+c] = clampUint8(v)
+		}
+	}
+}
+
+func preprocessDenoise(im *image.RGBA, radius int) {
+
+	w := im.Rect.Dx()
+	h := im.Rect.Dy()
+
+	src := make([]uint8, len(im.Pix))
+	copy(src, im.Pix)
+
+	window := make([]int, 0, (radius*2+1)*(radius*2+1))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			for c := 0; c < 3; c++ {
+
+				window = window[:0]
+
+				for dy := -radius; dy <= radius; dy++ {
+					for dx := -radius; dx <= radius; dx++ {
+
+						nx := x + dx
+						ny := y + dy
+
+						if nx < 0 || ny < 0 || nx >= w || ny >= h {
+							continue
+						}
+
+						window = append(window, int(src[(ny*w+nx)*4+c]))
+					}
+				}
+
+				sort.Ints(window)
+				im.Pix[(y*w+x)*4+c] = uint8(window[len(window)/2])
+			}
+		}
+	}
+}
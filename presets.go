@@ -0,0 +1,140 @@
+package coloringbook
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sfomuseum/go-coloringbook/outline"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed presets.json
+var default_presets []byte
+
+// Presets is a dictionary of named outline presets. Each preset is a JSON-encoded `OutlineProperty` instance
+// whose values are merged with (default) outline options using MergeOutlineOptions.
+type Presets map[string]json.RawMessage
+
+// DefaultPresets returns the presets bundled with this package ("fine-line", "bold-kids" and "technical-drawing") and
+// the difficulty variant presets ("easy", "medium" and "hard").
+func DefaultPresets() (Presets, error) {
+
+	var p Presets
+
+	err := json.Unmarshal(default_presets, &p)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal default presets, %w", err)
+	}
+
+	return p, nil
+}
+
+// LoadPresets returns the default presets combined with the presets defined in the JSON or YAML (if 'path' ends in
+// ".yaml" or ".yml") file 'path'. Presets in 'path' replace default presets with the same name. If 'path' is empty only
+// the default presets are returned.
+func LoadPresets(path string) (Presets, error) {
+
+	p, err := DefaultPresets()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if path == "" {
+		return p, nil
+	}
+
+	body, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s, %w", path, err)
+	}
+
+	var custom Presets
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		custom, err = unmarshalYAMLPresets(body)
+	default:
+		err = json.Unmarshal(body, &custom)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal %s, %w", path, err)
+	}
+
+	for name, preset := range custom {
+		p[name] = preset
+	}
+
+	return p, nil
+}
+
+// Names returns the sorted list of preset names.
+func (p Presets) Names() []string {
+
+	names := make([]string, 0, len(p))
+
+	for name, _ := range p {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Apply returns a copy of 'opts' with the values of the preset 'name' applied. If 'name' is empty a copy of
+// 'opts' is returned unchanged.
+func (p Presets) Apply(opts *outline.OutlineOptions, name string) (*outline.OutlineOptions, error) {
+
+	if name == "" {
+		return CloneOutlineOptions(opts), nil
+	}
+
+	preset, exists := p[name]
+
+	if !exists {
+		return nil, fmt.Errorf("Unknown preset '%s'", name)
+	}
+
+	merged, err := MergeOutlineOptions(opts, preset)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to apply preset '%s', %w", name, err)
+	}
+
+	return merged, nil
+}
+
+// unmarshalYAMLPresets decodes the YAML document 'body' and returns each of its presets re-encoded as JSON.
+func unmarshalYAMLPresets(body []byte) (Presets, error) {
+
+	var raw map[string]any
+
+	err := yaml.Unmarshal(body, &raw)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := make(Presets)
+
+	for name, preset := range raw {
+
+		enc_preset, err := json.Marshal(preset)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to encode preset '%s', %w", name, err)
+		}
+
+		p[name] = enc_preset
+	}
+
+	return p, nil
+}
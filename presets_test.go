@@ -0,0 +1,63 @@
+package coloringbook
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPresets(t *testing.T) {
+
+	root := t.TempDir()
+
+	files := map[string]string{
+		"presets.json": `{"sketchy": {"contour": {"iterations": 4, "scale": 2.0}, "trace": {"speckle": 12}}}`,
+		"presets.yaml": "sketchy:\n  contour:\n    iterations: 4\n    scale: 2.0\n  trace:\n    speckle: 12\n",
+		"presets.yml":  "sketchy: {contour: {iterations: 4, scale: 2.0}, trace: {speckle: 12}}\n",
+	}
+
+	for name, body := range files {
+
+		path := filepath.Join(root, name)
+
+		err := os.WriteFile(path, []byte(body), 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write %s, %v", path, err)
+		}
+
+		p, err := LoadPresets(path)
+
+		if err != nil {
+			t.Fatalf("Failed to load %s, %v", name, err)
+		}
+
+		if _, ok := p["fine-line"]; !ok {
+			t.Fatalf("Expected %s presets to include the default presets", name)
+		}
+
+		opts, err := p.Apply(DefaultOutlineOptions(), "sketchy")
+
+		if err != nil {
+			t.Fatalf("Failed to apply preset from %s, %v", name, err)
+		}
+
+		if opts.Contour.Iterations != 4 || opts.Contour.Scale != 2.0 || opts.Trace.Speckle != 12 {
+			t.Fatalf("Unexpected options for preset from %s, %+v %+v", name, opts.Contour, opts.Trace)
+		}
+	}
+
+	invalid := filepath.Join(root, "invalid.yaml")
+
+	err := os.WriteFile(invalid, []byte("sketchy: [unclosed\n"), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write %s, %v", invalid, err)
+	}
+
+	_, err = LoadPresets(invalid)
+
+	if err == nil {
+		t.Fatalf("Expected invalid YAML presets to fail")
+	}
+}
@@ -0,0 +1,281 @@
+package coloringbook
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// PrintOptions defines adjustments made to outline images so that they print consistently, regardless of the
+// resolution of the source image.
+type PrintOptions struct {
+	// The width of outline lines on the printed sheet, in inches. If 0 line weights are left unchanged.
+	LineWeight float64 `json:"line_weight,omitempty"`
+	// The minimum area of an enclosed (colorable) region on the printed sheet, in square inches. Smaller regions
+	// are merged with their neighbours. If 0 regions are left unchanged.
+	MinRegionArea float64 `json:"min_region_area,omitempty"`
+}
+
+// ParseLength parses 'str', a number followed by one of the units "pt", "mm", "cm" or "in", and returns its value in inches.
+func ParseLength(str string) (float64, error) {
+
+	str = strings.TrimSpace(strings.ToLower(str))
+
+	units := map[string]float64{
+		"pt": 1.0 / 72.0,
+		"mm": 1.0 / 25.4,
+		"cm": 1.0 / 2.54,
+		"in": 1.0,
+	}
+
+	for suffix, factor := range units {
+
+		if !strings.HasSuffix(str, suffix) {
+			continue
+		}
+
+		v, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(str, suffix)), 64)
+
+		if err != nil {
+			return 0, fmt.Errorf("Invalid length '%s', %w", str, err)
+		}
+
+		if v < 0 {
+			return 0, fmt.Errorf("Invalid length '%s', must not be negative", str)
+		}
+
+		return v * factor, nil
+	}
+
+	return 0, fmt.Errorf("Invalid length '%s', missing or unsupported unit (expected pt, mm, cm or in)", str)
+}
+
+// ApplyPrintOptions returns a copy of the outline image 'im' with its lines redrawn using the line weight, and
+// small regions merged using the minimum region area, defined in 'opts'. Sizes are converted to pixels using
+// PrintedPixelsPerInch so 'im' should be the image that will be passed to AddSheet.
+func ApplyPrintOptions(im image.Image, opts *PrintOptions) image.Image {
+
+	if opts == nil || (opts.LineWeight <= 0 && opts.MinRegionArea <= 0) {
+		return im
+	}
+
+	ppi := PrintedPixelsPerInch(im)
+
+	bounds := im.Bounds()
+	w := bounds.Dx()
+	h := bounds.Dy()
+
+	if w < 3 || h < 3 {
+		return im
+	}
+
+	// Reduce lines to their (single pixel) skeleton
+
+	inked := inkMask(im)
+
+	lines := make([]bool, len(inked))
+	copy(lines, inked)
+
+	thin(lines, w, h)
+
+	// Merge small regions
+
+	if opts.MinRegionArea > 0 {
+
+		min_pixels := int(math.Ceil(opts.MinRegionArea * ppi * ppi))
+		mergeSmallRegions(lines, w, h, min_pixels)
+
+		// Remove any stray pixels left behind by merging regions
+		removeSpeckles(lines, w, h, 3)
+	}
+
+	// Redraw lines
+
+	line_width := 1
+
+	if opts.LineWeight > 0 {
+		line_width = int(math.Max(1, math.Round(opts.LineWeight*ppi)))
+	} else {
+
+		// Approximate the original line weight as the ratio of inked pixels to skeleton pixels
+
+		inked_count := 0
+		skeleton_count := 0
+
+		for i := range lines {
+
+			if inked[i] {
+				inked_count += 1
+			}
+
+			if lines[i] {
+				skeleton_count += 1
+			}
+		}
+
+		if skeleton_count > 0 {
+			line_width = int(math.Max(1, math.Round(float64(inked_count)/float64(skeleton_count))))
+		}
+	}
+
+	return drawEdges(lines, w, h, line_width)
+}
+
+// mergeSmallRegions removes, in place, the line pixels separating any region enclosed by 'lines' which contains
+// fewer than 'min_pixels' pixels from its largest neighbouring region, merging the two. Regions touching the edge of
+// the image (the background) are never merged and line pixels which also border the background, or a third region,
+// are kept so that the subject's outer outline and any other regions are left intact.
+func mergeSmallRegions(lines []bool, w int, h int, min_pixels int) {
+
+	if min_pixels <= 1 {
+		return
+	}
+
+	// Label the (4-connected) regions between lines
+
+	labels := make([]int, w*h)
+
+	for i := range labels {
+		labels[i] = -1
+	}
+
+	sizes := make([]int, 0)
+	background := make([]bool, 0)
+
+	for start := range lines {
+
+		if lines[start] || labels[start] != -1 {
+			continue
+		}
+
+		label := len(sizes)
+		is_background := false
+
+		region := []int{start}
+		labels[start] = label
+
+		for i := 0; i < len(region); i++ {
+
+			x := region[i] % w
+			y := region[i] / w
+
+			if x == 0 || y == 0 || x == w-1 || y == h-1 {
+				is_background = true
+			}
+
+			neighbours := [][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}}
+
+			for _, n := range neighbours {
+
+				nx := n[0]
+				ny := n[1]
+
+				if nx < 0 || ny < 0 || nx >= w || ny >= h {
+					continue
+				}
+
+				j := ny*w + nx
+
+				if !lines[j] && labels[j] == -1 {
+					labels[j] = label
+					region = append(region, j)
+				}
+			}
+		}
+
+		sizes = append(sizes, len(region))
+		background = append(background, is_background)
+	}
+
+	// The labels of the regions in the 8-neighbourhood of the line pixel 'i'
+
+	adjacent := func(i int) map[int]bool {
+
+		x := i % w
+		y := i / w
+
+		found := make(map[int]bool)
+
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+
+				nx := x + dx
+				ny := y + dy
+
+				if nx < 0 || ny < 0 || nx >= w || ny >= h {
+					continue
+				}
+
+				j := ny*w + nx
+
+				if labels[j] != -1 {
+					found[labels[j]] = true
+				}
+			}
+		}
+
+		return found
+	}
+
+	// Find the line pixels bordering each small region, and the regions on their other side
+
+	boundaries := make(map[int][]int)
+
+	for i := range lines {
+
+		if !lines[i] {
+			continue
+		}
+
+		for label := range adjacent(i) {
+
+			if sizes[label] < min_pixels && !background[label] {
+				boundaries[label] = append(boundaries[label], i)
+			}
+		}
+	}
+
+	to_remove := make([]int, 0)
+
+	for label, boundary := range boundaries {
+
+		// The largest neighbouring region that isn't the background
+
+		largest := -1
+
+		for _, i := range boundary {
+
+			for n := range adjacent(i) {
+
+				if n == label || background[n] {
+					continue
+				}
+
+				if largest == -1 || sizes[n] > sizes[largest] || (sizes[n] == sizes[largest] && n < largest) {
+					largest = n
+				}
+			}
+		}
+
+		if largest == -1 {
+			continue
+		}
+
+		// Only remove the segment shared by the two regions
+
+		for _, i := range boundary {
+
+			found := adjacent(i)
+
+			if len(found) == 2 && found[label] && found[largest] {
+				to_remove = append(to_remove, i)
+			}
+		}
+	}
+
+	for _, i := range to_remove {
+		lines[i] = false
+	}
+}
@@ -0,0 +1,99 @@
+package coloringbook
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseLength(t *testing.T) {
+
+	tests := []struct {
+		str      string
+		expected float64
+		ok       bool
+	}{
+		{"1in", 1.0, true},
+		{"72pt", 1.0, true},
+		{"25.4mm", 1.0, true},
+		{"2.54cm", 1.0, true},
+		{" 0.5 IN ", 0.5, true},
+		{"0pt", 0.0, true},
+		{"1", 0, false},
+		{"1px", 0, false},
+		{"pt", 0, false},
+		{"-1mm", 0, false},
+		{"", 0, false},
+	}
+
+	for _, test := range tests {
+
+		v, err := ParseLength(test.str)
+
+		if !test.ok {
+
+			if err == nil {
+				t.Errorf("Expected '%s' to fail, got %f", test.str, v)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Failed to parse '%s', %v", test.str, err)
+			continue
+		}
+
+		if math.Abs(v-test.expected) > 1e-9 {
+			t.Errorf("Expected '%s' to be %f inches, got %f", test.str, test.expected, v)
+		}
+	}
+}
+
+func TestMergeSmallRegions(t *testing.T) {
+
+	w := 40
+	h := 40
+
+	lines := make([]bool, w*h)
+
+	set := func(x int, y int) {
+		lines[y*w+x] = true
+	}
+
+	// An outer box with a small region in its top-left corner
+
+	for i := 5; i <= 35; i++ {
+		set(i, 5)
+		set(i, 35)
+		set(5, i)
+		set(35, i)
+	}
+
+	for i := 5; i <= 10; i++ {
+		set(10, i)
+		set(i, 10)
+	}
+
+	mergeSmallRegions(lines, w, h, 50)
+
+	// The outer box must be intact
+
+	for i := 5; i <= 35; i++ {
+
+		for _, pt := range [][2]int{{i, 5}, {i, 35}, {5, i}, {35, i}} {
+
+			if !lines[pt[1]*w+pt[0]] {
+				t.Fatalf("Outer outline was opened at %d,%d", pt[0], pt[1])
+			}
+		}
+	}
+
+	// The boundary shared by the small region and the rest of the box must be removed
+
+	for i := 6; i <= 9; i++ {
+
+		if lines[i*w+10] || lines[10*w+i] {
+			t.Fatalf("Expected boundary of small region to be removed at %d", i)
+		}
+	}
+}
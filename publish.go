@@ -0,0 +1,145 @@
+package coloringbook
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3v2_types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"gocloud.dev/blob"
+)
+
+// PublishOptions defines the attributes assigned to files when they are published to a bucket.
+type PublishOptions struct {
+	// An optional AWS S3 canned ACL (for example "public-read") to assign to published files. This is
+	// ignored by non-S3 buckets.
+	ACL string
+	// An optional Cache-Control header to assign to published files.
+	CacheControl string
+	// An optional Content-Disposition header to assign to published files. The string "{filename}" will
+	// be replaced by the base name of the file being published.
+	ContentDisposition string
+	// The Content-Type header to assign to published PDF files.
+	PDFContentType string
+	// The Content-Type header to assign to published thumbnail images.
+	ThumbnailContentType string
+	// A boolean flag indicating whether the object and image IDs should be assigned as custom metadata
+	// ("object-id" and "image-id") to published files.
+	IncludeIds bool
+	// Optional custom metadata to assign to published files.
+	Metadata map[string]string
+}
+
+// ParseMetadata parses 'str', a comma-separated list of "key=value" pairs, in to a dictionary suitable for
+// use as `PublishOptions.Metadata`.
+func ParseMetadata(str string) (map[string]string, error) {
+
+	m := make(map[string]string)
+
+	if strings.TrimSpace(str) == "" {
+		return m, nil
+	}
+
+	for _, pair := range strings.Split(str, ",") {
+
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("Invalid metadata pair '%s', expected key=value", pair)
+		}
+
+		m[kv[0]] = kv[1]
+	}
+
+	return m, nil
+}
+
+// NewPublishWriter returns a new `blob.Writer` instance for writing 'key' to 'bucket' using the attributes defined
+// in 'opts'. 'content_type' is the Content-Type header for the file and 'object_id' and 'image_id' are the IDs of
+// object and image the file was derived from. The ACL attribute is only applied to S3 buckets; other gocloud.dev/blob
+// drivers will ignore it.
+func NewPublishWriter(ctx context.Context, bucket *blob.Bucket, key string, content_type string, opts *PublishOptions, object_id int64, image_id int64) (*blob.Writer, error) {
+
+	metadata := make(map[string]string)
+
+	for k, v := range opts.Metadata {
+		metadata[k] = v
+	}
+
+	if opts.IncludeIds {
+		metadata["object-id"] = strconv.FormatInt(object_id, 10)
+		metadata["image-id"] = strconv.FormatInt(image_id, 10)
+	}
+
+	wr_opts := &blob.WriterOptions{
+		ContentType:  content_type,
+		CacheControl: opts.CacheControl,
+		Metadata:     metadata,
+	}
+
+	if opts.ContentDisposition != "" {
+		wr_opts.ContentDisposition = strings.Replace(opts.ContentDisposition, "{filename}", filepath.Base(key), -1)
+	}
+
+	if opts.ACL != "" {
+
+		acl := opts.ACL
+
+		wr_opts.BeforeWrite = func(asFunc func(interface{}) bool) error {
+
+			// aws-sdk-go (v1)
+
+			var req *s3manager.UploadInput
+
+			if asFunc(&req) {
+				req.ACL = aws.String(acl)
+				return nil
+			}
+
+			// aws-sdk-go-v2 (s3blob buckets opened with "awssdk=v2")
+
+			var req_v2 *s3v2.PutObjectInput
+
+			if asFunc(&req_v2) {
+				req_v2.ACL = s3v2_types.ObjectCannedACL(acl)
+				return nil
+			}
+
+			// Not an S3 bucket
+
+			if !isS3Bucket(bucket) {
+				return nil
+			}
+
+			return fmt.Errorf("Unable to assign ACL '%s' to %s", acl, key)
+		}
+	}
+
+	wr, err := bucket.NewWriter(ctx, key, wr_opts)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create writer for %s, %w", key, err)
+	}
+
+	return wr, nil
+}
+
+// isS3Bucket returns a boolean value indicating whether 'bucket' is an AWS S3 bucket, using either version of the AWS SDK.
+func isS3Bucket(bucket *blob.Bucket) bool {
+
+	var client *s3.S3
+
+	if bucket.As(&client) {
+		return true
+	}
+
+	var client_v2 *s3v2.Client
+
+	return bucket.As(&client_v2)
+}
@@ -0,0 +1,437 @@
+package coloringbook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/nfnt/resize"
+	"github.com/sfomuseum/go-coloringbook/outline"
+	"github.com/tidwall/gjson"
+	"github.com/whosonfirst/go-reader"
+	wof_reader "github.com/whosonfirst/go-whosonfirst-reader"
+	"gocloud.dev/blob"
+)
+
+type PublishSheetOptions struct {
+	// The reader used to load object and image records.
+	Reader reader.Reader
+	// The bucket where files are published.
+	Bucket *blob.Bucket
+	// The attributes to assign to published files.
+	Publish *PublishOptions
+	// The options used to derive an outline image from the object's primary image. This is ignored if
+	// ObjectImage is not empty.
+	Outline *outline.OutlineOptions
+	// The Outliner used to derive outline images. If nil the default (vtracer) outliner is used.
+	Outliner Outliner
+	// An optional list of adjustments to apply to the object's primary image before it is outlined.
+	Preprocess []*PreprocessStep
+	// A boolean flag indicating that any per-object overrides, defined in the "millsfield:coloring_book_options"
+	// property of the object record, should not be merged with Outline.
+	IgnoreObjectOptions bool
+	// An optional path to an existing outline image to use instead of deriving one.
+	ObjectImage string
+	// An optional filename for the PDF file. If empty the value of DefaultFilename will be used.
+	Filename string
+	// A boolean flag indicating whether files should be published using a Who's On First -style tree.
+	AppendTree bool
+	// A boolean flag indicating that the sheet is being written to a staging bucket and should be
+	// flagged as pending review.
+	Stage bool
+	// An optional list of difficulty variants (for example "easy", "medium" and "hard") to publish. Each variant
+	// is the name of a preset which is applied after any per-object overrides. If empty a single sheet is published.
+	Variants []string
+	// The presets used to resolve Variants. If nil the default presets are used.
+	Presets Presets
+	// A boolean flag indicating that outline images should be cropped to the bounding box of their inked pixels, and
+	// then enlarged to fill the printable area of the page, before they are laid out.
+	Crop bool
+	// The number of pixels of padding to leave around the inked pixels when Crop is true.
+	CropPadding int
+	// Optional line weight and minimum region size adjustments, at final print size, applied to outline images
+	// (after any cropping) before they are laid out.
+	Print *PrintOptions
+	// The range of outline metrics considered acceptable. Sheets whose metrics fall outside the range are flagged in their
+	// manifest. If nil metrics are recorded but not checked.
+	QualityTarget *QualityTarget
+	// A boolean flag indicating that derived outline options should be tuned until the outline metrics fall inside QualityTarget.
+	AutoTune bool
+	// The maximum number of outlines to derive when AutoTune is true. If 0 then DEFAULT_AUTOTUNE_ATTEMPTS is used.
+	AutoTuneAttempts int
+	// A boolean flag indicating that sheets whose metrics fall outside QualityTarget should be rejected, rather than flagged.
+	RejectQuality bool
+	// Optional configuration for simplifying the paths in SVG outlines before they are rasterized and embedded in sheets.
+	// If nil SVG outlines are left unchanged. Raster (PNG) outlines are never simplified.
+	Simplify *SimplifyOptions
+	// Optional configuration for handling images with an alpha channel. If nil transparent images are composited onto white.
+	Alpha *AlphaOptions
+	// Optional configuration for decoding images. If nil images with more than DEFAULT_MAX_PIXELS pixels are refused.
+	Decode *DecodeImageOptions
+	// A boolean flag indicating that images should be downscaled to the resolution needed for the page layout before they
+	// are outlined. This is ignored when Crop is true since cropped outlines are enlarged to fill the page.
+	Downscale bool
+}
+
+// PublishSheet derives the coloring book sheet (or sheets, if difficulty variants are defined) for 'object_id' and
+// publishes the PDF files, thumbnail images and manifests to the bucket defined in 'opts'. It returns the manifest
+// for each sheet, in the order the variants were defined, and the (unmodified) object record.
+func PublishSheet(ctx context.Context, opts *PublishSheetOptions, object_id int64) ([]*Manifest, []byte, error) {
+
+	// Get object metadata
+
+	body, err := wof_reader.LoadBytes(ctx, opts.Reader, object_id)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to load feature for object, %v", err)
+	}
+
+	primary_rsp := gjson.GetBytes(body, "properties.millsfield:primary_image")
+
+	if !primary_rsp.Exists() {
+		return nil, nil, fmt.Errorf("Object is missing primary image property")
+	}
+
+	image_id := primary_rsp.Int()
+
+	sheet_opts := &AddSheetOptions{
+		URL:             fmt.Sprintf("https://collection.sfomuseum.org/objects/%d/", object_id),
+		Title:           gjson.GetBytes(body, "properties.wof:name").String(),
+		Date:            gjson.GetBytes(body, "properties.sfomuseum:date").String(),
+		CreditLine:      gjson.GetBytes(body, "properties.sfomuseum:creditline").String(),
+		AccessionNumber: gjson.GetBytes(body, "properties.sfomuseum:accession_number").String(),
+	}
+
+	// Derive outline options
+
+	outline_opts := opts.Outline
+
+	if opts.ObjectImage == "" && !opts.IgnoreObjectOptions {
+
+		outline_opts, err = ObjectOutlineOptions(opts.Outline, body)
+
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	outliner := opts.Outliner
+
+	if outliner == nil && opts.ObjectImage == "" {
+
+		outliner, err = NewOutliner(ctx, DEFAULT_OUTLINER_URI)
+
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	variants := opts.Variants
+
+	if len(variants) == 0 {
+		variants = []string{""}
+	} else if opts.ObjectImage != "" {
+		return nil, nil, fmt.Errorf("Difficulty variants can not be derived from an existing object image")
+	}
+
+	presets := opts.Presets
+
+	if presets == nil {
+
+		presets, err = DefaultPresets()
+
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	filename := opts.Filename
+
+	if filename == "" {
+		filename = DefaultFilename(object_id, image_id)
+	}
+
+	if opts.AppendTree {
+
+		tree_filename, err := AppendTree(object_id, filename)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		filename = tree_filename
+	}
+
+	// Publish sheets
+
+	created := time.Now().Unix()
+
+	manifests := make([]*Manifest, len(variants))
+	variant_names := make([]string, 0)
+
+	for i, variant := range variants {
+
+		variant_opts, err := presets.Apply(outline_opts, variant)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if opts.ObjectImage != "" {
+			variant_opts = nil
+		}
+
+		m, err := publishSheetFiles(ctx, opts, outliner, sheet_opts, variant_opts, VariantFilename(filename, variant), object_id, image_id)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		m.Variant = variant
+		m.Created = created
+
+		manifests[i] = m
+
+		if variant != "" {
+			variant_names = append(variant_names, variant)
+		}
+	}
+
+	// Publish manifests
+
+	for _, m := range manifests {
+
+		if len(variant_names) > 0 {
+			m.Variants = variant_names
+		}
+
+		manifest_filename := ManifestFilename(m.PDF)
+
+		err = WriteManifest(ctx, opts.Bucket, manifest_filename, opts.Publish, m)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to write manifest, %w", err)
+		}
+
+		log.Printf("Wrote %s\n", manifest_filename)
+	}
+
+	return manifests, body, nil
+}
+
+// publishSheetFiles derives a single coloring book sheet, using 'outliner' and 'outline_opts', and publishes its PDF file (to 'filename')
+// and thumbnail image. It returns a manifest describing the sheet which has not been published yet.
+func publishSheetFiles(ctx context.Context, opts *PublishSheetOptions, outliner Outliner, sheet_opts *AddSheetOptions, outline_opts *outline.OutlineOptions, filename string, object_id int64, image_id int64) (*Manifest, error) {
+
+	// Derive contoured image if necessary
+
+	var im image.Image
+
+	// The PNG-encoded bytes of 'im', if they are already available, so that AddSheet doesn't need to re-encode it
+	var im_body []byte
+
+	backend := ""
+	preprocess := ""
+
+	if opts.ObjectImage == "" {
+
+		backend = outliner.Backend(outline_opts)
+		preprocess = FormatPreprocessSteps(opts.Preprocess)
+
+		derive_opts := &DeriveObjectImageOptions{
+			Reader:     opts.Reader,
+			Outline:    outline_opts,
+			Outliner:   outliner,
+			Preprocess: opts.Preprocess,
+			Simplify:   opts.Simplify,
+			Alpha:      opts.Alpha,
+			Decode:     opts.Decode,
+			Downscale:  opts.Downscale && !opts.Crop,
+		}
+
+		if opts.AutoTune {
+
+			derive_opts.AutoTune = &AutoTuneOptions{
+				Target:      opts.QualityTarget,
+				MaxAttempts: opts.AutoTuneAttempts,
+			}
+		}
+
+		contoured_im, derived_opts, err := DeriveObjectOutline(ctx, derive_opts, image_id)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to derive object image, %v", err)
+		}
+
+		outline_opts = derived_opts
+
+		im, err = OutlineImage(ctx, contoured_im)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode object image, %w", err)
+		}
+
+	} else {
+
+		body, err := os.ReadFile(opts.ObjectImage)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s, %w", opts.ObjectImage, err)
+		}
+
+		decoded_im, format, err := DecodeImageWithOptions(bytes.NewReader(body), opts.Decode)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode image %s, %w", opts.ObjectImage, err)
+		}
+
+		im = decoded_im
+
+		// Sheets embed PNG images so any other format, including JPEG images which may have been
+		// rotated according to their EXIF orientation, is re-encoded by AddSheet.
+
+		if format == "png" {
+			im_body = body
+		}
+	}
+
+	// Check outline quality
+
+	metrics := ComputeOutlineMetrics(im)
+	issues := opts.QualityTarget.Check(metrics)
+
+	if len(issues) > 0 {
+
+		if opts.RejectQuality {
+			return nil, fmt.Errorf("Outline for object %d failed quality checks: %s", object_id, strings.Join(issues, "; "))
+		}
+
+		log.Printf("Outline for object %d failed quality checks: %s\n", object_id, strings.Join(issues, "; "))
+	}
+
+	if opts.Crop {
+
+		cropped_im := CropToContent(im, opts.CropPadding)
+
+		if cropped_im != im {
+
+			log.Printf("Cropped image from %v to %v\n", im.Bounds(), cropped_im.Bounds())
+
+			im = cropped_im
+			im_body = nil
+		}
+
+		// Enlarge the cropped image before any print options are applied so that line weights are
+		// measured at the final print size.
+
+		enlarged_im := EnlargeToPrintableArea(im)
+
+		if enlarged_im != im {
+
+			log.Printf("Enlarged image from %v to %v\n", im.Bounds(), enlarged_im.Bounds())
+
+			im = enlarged_im
+			im_body = nil
+		}
+	}
+
+	if opts.Print != nil && (opts.Print.LineWeight > 0 || opts.Print.MinRegionArea > 0) {
+
+		im = ApplyPrintOptions(im, opts.Print)
+		im_body = nil
+	}
+
+	orientation := Orientation(im)
+
+	// Create PDF
+
+	pdf := fpdf.New(orientation, "in", PAGE_SIZE, "")
+
+	// Add sheet to colouring book
+
+	page_opts := *sheet_opts
+	page_opts.Image = im
+	page_opts.ImageName = path.Base(filename)
+
+	if im_body != nil {
+		page_opts.ImageReader = bytes.NewReader(im_body)
+	}
+
+	err := AddSheet(ctx, pdf, &page_opts)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to add sheet, %v", err)
+	}
+
+	// Publish PDF file
+
+	pdf_wr, err := NewPublishWriter(ctx, opts.Bucket, filename, opts.Publish.PDFContentType, opts.Publish, object_id, image_id)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create new writer for %s, %v", filename, err)
+	}
+
+	err = pdf.OutputAndClose(pdf_wr)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to write %s, %v", filename, err)
+	}
+
+	log.Printf("Wrote %s\n", filename)
+
+	// Publish thumb
+
+	thumb_im := resize.Thumbnail(600, 600, im, resize.Lanczos3)
+
+	thumb_filename := ThumbnailFilename(filename)
+
+	thumb_wr, err := NewPublishWriter(ctx, opts.Bucket, thumb_filename, opts.Publish.ThumbnailContentType, opts.Publish, object_id, image_id)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create new writer for %s, %v", thumb_filename, err)
+	}
+
+	err = png.Encode(thumb_wr, thumb_im)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode %s, %w", thumb_filename, err)
+	}
+
+	err = thumb_wr.Close()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to close %s, %w", thumb_filename, err)
+	}
+
+	log.Printf("Wrote %s\n", thumb_filename)
+
+	manifest := &Manifest{
+		ObjectId:      object_id,
+		ImageId:       image_id,
+		PDF:           filename,
+		Thumbnail:     thumb_filename,
+		Outline:       NewOutlineProperty(outline_opts),
+		Backend:       backend,
+		Preprocess:    preprocess,
+		Print:         opts.Print,
+		Metrics:       metrics,
+		QualityIssues: issues,
+		PageSize:      PAGE_SIZE,
+		Orientation:   orientation,
+		Status:        MANIFEST_STATUS_PUBLISHED,
+	}
+
+	if opts.Stage {
+		manifest.Status = MANIFEST_STATUS_PENDING
+	}
+
+	return manifest, nil
+}
@@ -0,0 +1,591 @@
+package coloringbook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/sfomuseum/go-coloringbook/outline"
+	"github.com/sfomuseum/go-svg"
+)
+
+// DEFAULT_QUALITY_TARGET is the default specification (as parsed by ParseQualityTarget) of the range of outline metrics
+// considered acceptable for a coloring book sheet.
+const DEFAULT_QUALITY_TARGET string = "ink-coverage=0.01:0.25,regions=3:1000,average-region-size=:0.25,line-density=2:100"
+
+// DEFAULT_AUTOTUNE_ATTEMPTS is the default maximum number of outlines derived by AutoTune.
+const DEFAULT_AUTOTUNE_ATTEMPTS int = 6
+
+// enclosed regions smaller than this fraction of the image area are considered artifacts and not counted by ComputeOutlineMetrics.
+const metrics_min_region_fraction float64 = 0.0001
+
+const (
+	// QUALITY_INK_COVERAGE is the name of the OutlineMetrics.InkCoverage metric.
+	QUALITY_INK_COVERAGE string = "ink-coverage"
+	// QUALITY_REGIONS is the name of the OutlineMetrics.Regions metric.
+	QUALITY_REGIONS string = "regions"
+	// QUALITY_AVERAGE_REGION_SIZE is the name of the OutlineMetrics.AverageRegionSize metric.
+	QUALITY_AVERAGE_REGION_SIZE string = "average-region-size"
+	// QUALITY_LINE_DENSITY is the name of the OutlineMetrics.LineDensity metric.
+	QUALITY_LINE_DENSITY string = "line-density"
+)
+
+// OutlineMetrics describes the properties of an outline image used to judge whether it will make a good coloring book sheet.
+type OutlineMetrics struct {
+	// The fraction of pixels in the image which are inked.
+	InkCoverage float64 `json:"ink_coverage"`
+	// The number of closed (colorable) regions in the image. Regions touching the edge of the image are not counted.
+	Regions int `json:"regions"`
+	// The average size of the closed regions in the image, as a fraction of the image area.
+	AverageRegionSize float64 `json:"average_region_size"`
+	// The total length of the lines in the image, as a multiple of the length of the image diagonal.
+	LineDensity float64 `json:"line_density"`
+}
+
+// QualityRange defines the minimum and maximum acceptable values for an outline metric. A value of 0 means no bound.
+type QualityRange struct {
+	Min float64 `json:"min,omitempty"`
+	Max float64 `json:"max,omitempty"`
+}
+
+// QualityTarget defines the acceptable ranges for outline metrics. Metrics with a nil range are not checked.
+type QualityTarget struct {
+	InkCoverage       *QualityRange `json:"ink_coverage,omitempty"`
+	Regions           *QualityRange `json:"regions,omitempty"`
+	AverageRegionSize *QualityRange `json:"average_region_size,omitempty"`
+	LineDensity       *QualityRange `json:"line_density,omitempty"`
+}
+
+// AutoTuneOptions defines configuration options for the AutoTune method.
+type AutoTuneOptions struct {
+	// The range of outline metrics to search for.
+	Target *QualityTarget
+	// The maximum number of outlines to derive. If 0 then DEFAULT_AUTOTUNE_ATTEMPTS is used.
+	MaxAttempts int
+}
+
+// AutoTuneResult is the outline, and the options used to derive it, whose metrics came closest to an AutoTune target.
+type AutoTuneResult struct {
+	Outline outline.Outline
+	Options *outline.OutlineOptions
+	Metrics *OutlineMetrics
+	// The metrics which are outside the target range, if any.
+	Issues []string
+	// The number of outlines derived.
+	Attempts int
+}
+
+// qualityMetric describes how to read a single metric and whether higher values mean a more complex outline.
+type qualityMetric struct {
+	name    string
+	label   string
+	value   func(*OutlineMetrics) float64
+	target  func(*QualityTarget) **QualityRange
+	complex bool
+}
+
+var qualityMetrics = []qualityMetric{
+	{
+		name:    QUALITY_INK_COVERAGE,
+		label:   "ink coverage",
+		value:   func(m *OutlineMetrics) float64 { return m.InkCoverage },
+		target:  func(t *QualityTarget) **QualityRange { return &t.InkCoverage },
+		complex: true,
+	},
+	{
+		name:    QUALITY_REGIONS,
+		label:   "number of regions",
+		value:   func(m *OutlineMetrics) float64 { return float64(m.Regions) },
+		target:  func(t *QualityTarget) **QualityRange { return &t.Regions },
+		complex: true,
+	},
+	{
+		name:    QUALITY_AVERAGE_REGION_SIZE,
+		label:   "average region size",
+		value:   func(m *OutlineMetrics) float64 { return m.AverageRegionSize },
+		target:  func(t *QualityTarget) **QualityRange { return &t.AverageRegionSize },
+		complex: false,
+	},
+	{
+		name:    QUALITY_LINE_DENSITY,
+		label:   "line density",
+		value:   func(m *OutlineMetrics) float64 { return m.LineDensity },
+		target:  func(t *QualityTarget) **QualityRange { return &t.LineDensity },
+		complex: true,
+	},
+}
+
+// ParseQualityTarget parses 'spec', a comma-separated list of "metric=min:max" pairs, and returns the corresponding `QualityTarget`.
+// Either 'min' or 'max' may be empty to leave that side of the range unbounded. Valid metrics are: ink-coverage, regions,
+// average-region-size, line-density. If 'spec' is empty nil is returned.
+func ParseQualityTarget(spec string) (*QualityTarget, error) {
+
+	spec = strings.TrimSpace(spec)
+
+	if spec == "" {
+		return nil, nil
+	}
+
+	t := &QualityTarget{}
+
+	for _, str_pair := range strings.Split(spec, ",") {
+
+		str_pair = strings.TrimSpace(str_pair)
+
+		if str_pair == "" {
+			continue
+		}
+
+		name, str_range, ok := strings.Cut(str_pair, "=")
+
+		if !ok {
+			return nil, fmt.Errorf("Invalid quality target '%s', expected metric=min:max", str_pair)
+		}
+
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		var metric *qualityMetric
+
+		for i, m := range qualityMetrics {
+
+			if m.name == name {
+				metric = &qualityMetrics[i]
+				break
+			}
+		}
+
+		if metric == nil {
+			return nil, fmt.Errorf("Invalid quality metric '%s'", name)
+		}
+
+		str_min, str_max, ok := strings.Cut(str_range, ":")
+
+		if !ok {
+			return nil, fmt.Errorf("Invalid range for quality metric '%s', expected min:max", name)
+		}
+
+		r := &QualityRange{}
+
+		for _, b := range []struct {
+			str   string
+			value *float64
+		}{
+			{str_min, &r.Min},
+			{str_max, &r.Max},
+		} {
+
+			str := strings.TrimSpace(b.str)
+
+			if str == "" {
+				continue
+			}
+
+			v, err := strconv.ParseFloat(str, 64)
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid range for quality metric '%s', %w", name, err)
+			}
+
+			if v < 0 {
+				return nil, fmt.Errorf("Invalid range for quality metric '%s', values must not be negative", name)
+			}
+
+			*b.value = v
+		}
+
+		if r.Max > 0 && r.Min > r.Max {
+			return nil, fmt.Errorf("Invalid range for quality metric '%s', min must not be greater than max", name)
+		}
+
+		*metric.target(t) = r
+	}
+
+	return t, nil
+}
+
+// ComputeOutlineMetrics returns the `OutlineMetrics` for the outline image 'im'.
+func ComputeOutlineMetrics(im image.Image) *OutlineMetrics {
+
+	bounds := im.Bounds()
+
+	w := bounds.Dx()
+	h := bounds.Dy()
+
+	m := &OutlineMetrics{}
+
+	if w == 0 || h == 0 {
+		return m
+	}
+
+	area := w * h
+	inked := inkMask(im)
+
+	// Ink coverage
+
+	inked_count := 0
+
+	for _, v := range inked {
+
+		if v {
+			inked_count += 1
+		}
+	}
+
+	m.InkCoverage = float64(inked_count) / float64(area)
+
+	// Closed regions
+
+	min_region := int(math.Max(1, math.Ceil(float64(area)*metrics_min_region_fraction)))
+
+	visited := make([]bool, area)
+	region_pixels := 0
+
+	for start := range inked {
+
+		if inked[start] || visited[start] {
+			continue
+		}
+
+		size := 0
+		closed := true
+
+		queue := []int{start}
+		visited[start] = true
+
+		for len(queue) > 0 {
+
+			i := queue[0]
+			queue = queue[1:]
+
+			size += 1
+
+			x := i % w
+			y := i / w
+
+			if x == 0 || y == 0 || x == w-1 || y == h-1 {
+				closed = false
+			}
+
+			neighbours := [][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}}
+
+			for _, n := range neighbours {
+
+				nx := n[0]
+				ny := n[1]
+
+				if nx < 0 || ny < 0 || nx >= w || ny >= h {
+					continue
+				}
+
+				j := ny*w + nx
+
+				if !inked[j] && !visited[j] {
+					visited[j] = true
+					queue = append(queue, j)
+				}
+			}
+		}
+
+		if closed && size >= min_region {
+			m.Regions += 1
+			region_pixels += size
+		}
+	}
+
+	if m.Regions > 0 {
+		m.AverageRegionSize = float64(region_pixels) / float64(m.Regions) / float64(area)
+	}
+
+	// Line density
+
+	if w >= 3 && h >= 3 {
+
+		lines := make([]bool, area)
+		copy(lines, inked)
+
+		thin(lines, w, h)
+
+		length := 0
+
+		for _, v := range lines {
+
+			if v {
+				length += 1
+			}
+		}
+
+		diagonal := math.Sqrt(float64(w*w + h*h))
+		m.LineDensity = float64(length) / diagonal
+	}
+
+	return m
+}
+
+// Check returns a list of human-readable descriptions of the metrics in 'm' which fall outside the ranges defined by 't'.
+// If 't' is nil or all the metrics are in range an empty list is returned.
+func (t *QualityTarget) Check(m *OutlineMetrics) []string {
+
+	issues := make([]string, 0)
+
+	if t == nil {
+		return issues
+	}
+
+	for _, metric := range qualityMetrics {
+
+		r := *metric.target(t)
+
+		if r == nil {
+			continue
+		}
+
+		v := metric.value(m)
+
+		if r.Min > 0 && v < r.Min {
+			issues = append(issues, fmt.Sprintf("%s (%s) is below the minimum (%s)", metric.label, formatMetric(v), formatMetric(r.Min)))
+		}
+
+		if r.Max > 0 && v > r.Max {
+			issues = append(issues, fmt.Sprintf("%s (%s) is above the maximum (%s)", metric.label, formatMetric(v), formatMetric(r.Max)))
+		}
+	}
+
+	return issues
+}
+
+// distance returns the sum of the relative distances of the metrics in 'm' from the ranges defined by 't' and
+// whether the outline should be made simpler (1), more detailed (-1) or left unchanged (0) to reduce it.
+func (t *QualityTarget) distance(m *OutlineMetrics) (float64, int) {
+
+	d := 0.0
+	direction := 0
+
+	if t == nil {
+		return d, direction
+	}
+
+	for _, metric := range qualityMetrics {
+
+		r := *metric.target(t)
+
+		if r == nil {
+			continue
+		}
+
+		v := metric.value(m)
+		too_complex := 0
+
+		if r.Min > 0 && v < r.Min {
+			d += (r.Min - v) / r.Min
+			too_complex = -1
+		}
+
+		if r.Max > 0 && v > r.Max {
+			d += (v - r.Max) / r.Max
+			too_complex = 1
+		}
+
+		if !metric.complex {
+			too_complex = -too_complex
+		}
+
+		direction += too_complex
+	}
+
+	switch {
+	case direction > 0:
+		direction = 1
+	case direction < 0:
+		direction = -1
+	}
+
+	return d, direction
+}
+
+func formatMetric(v float64) string {
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
+
+// OutlineImage decodes the outline 'o', in memory. Vector (SVG) outlines are rasterized using the native (Go) rasterizer.
+func OutlineImage(ctx context.Context, o outline.Outline) (image.Image, error) {
+
+	// Outlines derived by this package already wrap an image so there is no need to encode and decode it
+
+	if im_o, ok := o.(*imageOutline); ok {
+		return im_o.image, nil
+	}
+
+	var buf bytes.Buffer
+
+	err := o.Write(ctx, &buf)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to write outline, %w", err)
+	}
+
+	if isSVG(buf.Bytes()) {
+
+		im, err := svg.Rasterize(ctx, &buf)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to rasterize outline, %w", err)
+		}
+
+		return im, nil
+	}
+
+	im, _, err := image.Decode(&buf)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode outline, %w", err)
+	}
+
+	return im, nil
+}
+
+// AutoTune derives outlines for 'im' using 'outliner', adjusting the contour iterations and the trace speckle and precision values
+// in 'opts' after each attempt, until the outline's metrics fall inside the range defined by 'tune_opts' or the maximum number of
+// attempts is reached. It returns the outline which came closest to the target range. If 'opts', or any of its contour, trace or
+// rasterize options, are nil the values returned by DefaultOutlineOptions are used.
+func AutoTune(ctx context.Context, outliner Outliner, im image.Image, opts *outline.OutlineOptions, tune_opts *AutoTuneOptions) (*AutoTuneResult, error) {
+
+	if tune_opts == nil || tune_opts.Target == nil {
+		return nil, fmt.Errorf("Missing auto-tune target")
+	}
+
+	// The search adjusts the contour and trace options so make sure they are present
+
+	opts = completeOutlineOptions(opts)
+
+	max_attempts := tune_opts.MaxAttempts
+
+	if max_attempts <= 0 {
+		max_attempts = DEFAULT_AUTOTUNE_ATTEMPTS
+	}
+
+	// Metrics can only be derived from raster outlines so search using PNG outlines and
+	// derive the final outline in the requested format once the search is complete.
+
+	format := opts.Contour.Format
+
+	candidate_opts := CloneOutlineOptions(opts)
+	candidate_opts.Contour.Format = "png"
+
+	var best *AutoTuneResult
+	best_distance := math.MaxFloat64
+
+	visited := make(map[string]bool)
+	attempts := 0
+
+	for attempts < max_attempts {
+
+		key := autoTuneKey(candidate_opts)
+
+		if visited[key] {
+			break
+		}
+
+		visited[key] = true
+		attempts += 1
+
+		o, err := outliner.Outline(ctx, im, candidate_opts)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to derive outline (%s), %w", key, err)
+		}
+
+		outline_im, err := OutlineImage(ctx, o)
+
+		if err != nil {
+			return nil, err
+		}
+
+		metrics := ComputeOutlineMetrics(outline_im)
+		d, direction := tune_opts.Target.distance(metrics)
+
+		log.Printf("Auto-tune attempt %d (%s): ink coverage %s, %d regions, average region size %s, line density %s\n", attempts, key, formatMetric(metrics.InkCoverage), metrics.Regions, formatMetric(metrics.AverageRegionSize), formatMetric(metrics.LineDensity))
+
+		if d < best_distance {
+
+			best_distance = d
+
+			best = &AutoTuneResult{
+				Outline: o,
+				Options: candidate_opts,
+				Metrics: metrics,
+				Issues:  tune_opts.Target.Check(metrics),
+			}
+		}
+
+		if d == 0 || direction == 0 {
+			break
+		}
+
+		candidate_opts = adjustOutlineOptions(candidate_opts, direction)
+	}
+
+	best.Attempts = attempts
+
+	if format != "" && format != "png" {
+
+		best.Options = CloneOutlineOptions(best.Options)
+		best.Options.Contour.Format = format
+
+		o, err := outliner.Outline(ctx, im, best.Options)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to derive outline, %w", err)
+		}
+
+		best.Outline = o
+	}
+
+	return best, nil
+}
+
+// adjustOutlineOptions returns a copy of 'opts' adjusted to produce a simpler (direction > 0) or more detailed (direction < 0) outline.
+func adjustOutlineOptions(opts *outline.OutlineOptions, direction int) *outline.OutlineOptions {
+
+	new_opts := CloneOutlineOptions(opts)
+
+	clamp := func(v int, min int, max int) int {
+		return int(math.Max(float64(min), math.Min(float64(max), float64(v))))
+	}
+
+	if new_opts.Contour != nil {
+		new_opts.Contour.Iterations = clamp(new_opts.Contour.Iterations-2*direction, 2, 32)
+	}
+
+	if new_opts.Trace != nil {
+
+		if direction > 0 {
+			new_opts.Trace.Speckle = clamp(new_opts.Trace.Speckle*2, 1, 256)
+		} else {
+			new_opts.Trace.Speckle = clamp(new_opts.Trace.Speckle/2, 1, 256)
+		}
+
+		new_opts.Trace.Precision = clamp(new_opts.Trace.Precision-direction, 1, 8)
+	}
+
+	return new_opts
+}
+
+func autoTuneKey(opts *outline.OutlineOptions) string {
+
+	parts := make([]string, 0)
+
+	if opts.Contour != nil {
+		parts = append(parts, fmt.Sprintf("iterations=%d", opts.Contour.Iterations))
+	}
+
+	if opts.Trace != nil {
+		parts = append(parts, fmt.Sprintf("speckle=%d", opts.Trace.Speckle), fmt.Sprintf("precision=%d", opts.Trace.Precision))
+	}
+
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,149 @@
+package coloringbook
+
+import (
+	"context"
+	"image"
+	"testing"
+
+	"github.com/sfomuseum/go-coloringbook/outline"
+)
+
+func TestParseQualityTarget(t *testing.T) {
+
+	tests := []struct {
+		spec     string
+		ok       bool
+		expected func(*QualityTarget) bool
+	}{
+		{"", true, func(qt *QualityTarget) bool { return qt == nil }},
+		{"ink-coverage=0.01:0.25", true, func(qt *QualityTarget) bool {
+			return qt.InkCoverage != nil && qt.InkCoverage.Min == 0.01 && qt.InkCoverage.Max == 0.25 && qt.Regions == nil
+		}},
+		{"regions=3:, average-region-size=:0.25", true, func(qt *QualityTarget) bool {
+			return qt.Regions.Min == 3 && qt.Regions.Max == 0 && qt.AverageRegionSize.Min == 0 && qt.AverageRegionSize.Max == 0.25
+		}},
+		{"LINE-DENSITY=2:100,", true, func(qt *QualityTarget) bool {
+			return qt.LineDensity.Min == 2 && qt.LineDensity.Max == 100
+		}},
+		{DEFAULT_QUALITY_TARGET, true, func(qt *QualityTarget) bool {
+			return qt.InkCoverage != nil && qt.Regions != nil && qt.AverageRegionSize != nil && qt.LineDensity != nil
+		}},
+		{"ink-coverage", false, nil},
+		{"ink-coverage=0.1", false, nil},
+		{"colour=1:2", false, nil},
+		{"regions=a:2", false, nil},
+		{"regions=-1:2", false, nil},
+		{"regions=10:2", false, nil},
+	}
+
+	for _, test := range tests {
+
+		qt, err := ParseQualityTarget(test.spec)
+
+		if !test.ok {
+
+			if err == nil {
+				t.Errorf("Expected '%s' to fail", test.spec)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Failed to parse '%s', %v", test.spec, err)
+			continue
+		}
+
+		if !test.expected(qt) {
+			t.Errorf("Unexpected target for '%s', %+v", test.spec, qt)
+		}
+	}
+}
+
+func TestQualityTargetCheck(t *testing.T) {
+
+	qt, err := ParseQualityTarget("ink-coverage=0.01:0.25,regions=3:")
+
+	if err != nil {
+		t.Fatalf("Failed to parse target, %v", err)
+	}
+
+	tests := []struct {
+		metrics *OutlineMetrics
+		issues  int
+	}{
+		{&OutlineMetrics{InkCoverage: 0.1, Regions: 10}, 0},
+		{&OutlineMetrics{InkCoverage: 0.5, Regions: 10}, 1},
+		{&OutlineMetrics{InkCoverage: 0.001, Regions: 1}, 2},
+		{&OutlineMetrics{InkCoverage: 0.1, Regions: 100000}, 0},
+	}
+
+	for i, test := range tests {
+
+		issues := qt.Check(test.metrics)
+
+		if len(issues) != test.issues {
+			t.Errorf("Expected %d issues for test %d, got %v", test.issues, i, issues)
+		}
+	}
+
+	var nil_target *QualityTarget
+
+	if len(nil_target.Check(&OutlineMetrics{})) != 0 {
+		t.Errorf("Expected nil target to report no issues")
+	}
+}
+
+func TestAutoTuneOptions(t *testing.T) {
+
+	ctx := context.Background()
+
+	outliner, err := NewOutliner(ctx, "native://")
+
+	if err != nil {
+		t.Fatalf("Failed to create native outliner, %v", err)
+	}
+
+	target, err := ParseQualityTarget(DEFAULT_QUALITY_TARGET)
+
+	if err != nil {
+		t.Fatalf("Failed to parse quality target, %v", err)
+	}
+
+	im := newTestOutline(32, 32, image.Rect(8, 8, 24, 24))
+
+	tests := []struct {
+		name      string
+		opts      *outline.OutlineOptions
+		tune_opts *AutoTuneOptions
+		ok        bool
+	}{
+		{"nil options", nil, &AutoTuneOptions{Target: target, MaxAttempts: 2}, true},
+		{"nil contour", &outline.OutlineOptions{}, &AutoTuneOptions{Target: target, MaxAttempts: 2}, true},
+		{"default options", DefaultOutlineOptions(), &AutoTuneOptions{Target: target, MaxAttempts: 2}, true},
+		{"nil auto-tune options", nil, nil, false},
+		{"nil target", nil, &AutoTuneOptions{}, false},
+	}
+
+	for _, test := range tests {
+
+		rsp, err := AutoTune(ctx, outliner, im, test.opts, test.tune_opts)
+
+		if !test.ok {
+
+			if err == nil {
+				t.Fatalf("Expected %s to fail", test.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("Failed to auto-tune with %s, %v", test.name, err)
+		}
+
+		if rsp.Outline == nil || rsp.Options == nil || rsp.Options.Contour == nil {
+			t.Fatalf("Expected %s to return an outline and complete options", test.name)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package coloringbook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/sfomuseum/go-coloringbook/outline"
+	sfom_writer "github.com/sfomuseum/go-sfomuseum-writer/v3"
+	"github.com/tidwall/gjson"
+	"github.com/whosonfirst/go-whosonfirst-export/v2"
+	gh_writer "github.com/whosonfirst/go-writer-github/v3"
+	"github.com/whosonfirst/go-writer/v3"
+)
+
+// HAS_COLORING_BOOK_PROPERTY is the (GeoJSON) path of the property flagging that an object has a coloring book sheet.
+const HAS_COLORING_BOOK_PROPERTY string = "properties.millsfield:has_coloring_book"
+
+// COLORING_BOOK_PROPERTY is the (GeoJSON) path of the property describing an object's published coloring book sheet.
+const COLORING_BOOK_PROPERTY string = "properties.millsfield:coloring_book"
+
+// COLORING_BOOK_OPTIONS_PROPERTY is the (GeoJSON) path of the property containing per-object outline options.
+const COLORING_BOOK_OPTIONS_PROPERTY string = "properties.millsfield:coloring_book_options"
+
+// ColoringBookProperty describes a published coloring book sheet. It is stored in the
+// "millsfield:coloring_book" property of an object record.
+type ColoringBookProperty struct {
+	// The URI of the published PDF file.
+	PDF string `json:"pdf"`
+	// The URI of the published thumbnail image.
+	Thumbnail string `json:"thumbnail"`
+	// The ID of the image used to derive the sheet.
+	ImageId int64 `json:"image_id"`
+	// The options used to derive the outline image. This will be nil if the sheet was
+	// created from a pre-existing outline image.
+	Outline *OutlineProperty `json:"outline,omitempty"`
+	// The page size of the PDF file.
+	PageSize string `json:"page_size"`
+	// The page orientation of the PDF file ("P" or "L").
+	Orientation string `json:"orientation"`
+	// The name of the difficulty variant of the sheet, if any.
+	Variant string `json:"variant,omitempty"`
+	// The difficulty variants published alongside the sheet (including the sheet itself), if any.
+	Variants []*ColoringBookProperty `json:"variants,omitempty"`
+	// The Unix timestamp when the sheet was generated.
+	Created int64 `json:"created"`
+}
+
+// OutlineProperty is a JSON-encodable representation of `outline.OutlineOptions`.
+type OutlineProperty struct {
+	Contour   *ContourProperty   `json:"contour,omitempty"`
+	Trace     *TraceProperty     `json:"trace,omitempty"`
+	Rasterize *RasterizeProperty `json:"rasterize,omitempty"`
+}
+
+type ContourProperty struct {
+	Iterations int     `json:"iterations"`
+	Scale      float64 `json:"scale"`
+	Format     string  `json:"format"`
+}
+
+type TraceProperty struct {
+	Precision int `json:"precision"`
+	Speckle   int `json:"speckle"`
+}
+
+type RasterizeProperty struct {
+	UseBatik bool `json:"use_batik"`
+}
+
+// NewOutlineProperty returns a new `OutlineProperty` instance derived from 'opts'.
+func NewOutlineProperty(opts *outline.OutlineOptions) *OutlineProperty {
+
+	if opts == nil {
+		return nil
+	}
+
+	p := &OutlineProperty{}
+
+	if opts.Contour != nil {
+		p.Contour = &ContourProperty{
+			Iterations: opts.Contour.Iterations,
+			Scale:      opts.Contour.Scale,
+			Format:     opts.Contour.Format,
+		}
+	}
+
+	if opts.Trace != nil {
+		p.Trace = &TraceProperty{
+			Precision: opts.Trace.Precision,
+			Speckle:   opts.Trace.Speckle,
+		}
+	}
+
+	if opts.Rasterize != nil {
+		p.Rasterize = &RasterizeProperty{
+			UseBatik: opts.Rasterize.UseBatik,
+		}
+	}
+
+	return p
+}
+
+// AssignColoringBookProperties assigns 'prop' to the "millsfield:coloring_book" property of 'body' and flags
+// it as having a coloring book. If the existing property describes the same PDF file and image ID its creation
+// timestamp (and those of any matching variants) is preserved so that regenerating an unchanged sheet doesn't
+// change the record. It returns a boolean value indicating whether 'body' was changed and the updated body.
+func AssignColoringBookProperties(ctx context.Context, body []byte, prop *ColoringBookProperty) (bool, []byte, error) {
+
+	prop = preserveCreated(body, prop)
+
+	prop_value, err := jsonValue(prop)
+
+	if err != nil {
+		return false, nil, err
+	}
+
+	updates := map[string]interface{}{
+		HAS_COLORING_BOOK_PROPERTY: 1,
+		COLORING_BOOK_PROPERTY:     prop_value,
+	}
+
+	has_updates, new_body, err := export.AssignPropertiesIfChanged(ctx, body, updates)
+
+	if err != nil {
+		return false, nil, fmt.Errorf("Failed to assign updates to object record, %w", err)
+	}
+
+	return has_updates, new_body, nil
+}
+
+// jsonValue returns the generic (map, slice, float64, etc.) JSON representation of 'v'. Properties are assigned using their generic
+// representation because `export.AssignPropertiesIfChanged` compares them with existing values decoded the same way; structs
+// encode their keys in a different order and so would always be considered changed.
+func jsonValue(v interface{}) (interface{}, error) {
+
+	enc, err := json.Marshal(v)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal property, %w", err)
+	}
+
+	var value interface{}
+
+	err = json.Unmarshal(enc, &value)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal property, %w", err)
+	}
+
+	return value, nil
+}
+
+// preserveCreated returns a copy of 'prop' whose Created timestamps are replaced by those of the existing "millsfield:coloring_book"
+// property in 'body' (and its variants) describing the same PDF file and image ID. If there is no existing property 'prop' is returned.
+func preserveCreated(body []byte, prop *ColoringBookProperty) *ColoringBookProperty {
+
+	if prop == nil {
+		return prop
+	}
+
+	rsp := gjson.GetBytes(body, COLORING_BOOK_PROPERTY)
+
+	if !rsp.Exists() {
+		return prop
+	}
+
+	var existing *ColoringBookProperty
+
+	err := json.Unmarshal([]byte(rsp.Raw), &existing)
+
+	if err != nil || existing == nil {
+		return prop
+	}
+
+	created := make(map[string]int64)
+
+	for _, p := range append([]*ColoringBookProperty{existing}, existing.Variants...) {
+
+		if p != nil {
+			created[fmt.Sprintf("%s#%d", p.PDF, p.ImageId)] = p.Created
+		}
+	}
+
+	preserve := func(p *ColoringBookProperty) *ColoringBookProperty {
+
+		copy_p := *p
+
+		t, ok := created[fmt.Sprintf("%s#%d", p.PDF, p.ImageId)]
+
+		if ok {
+			copy_p.Created = t
+		}
+
+		return &copy_p
+	}
+
+	new_prop := preserve(prop)
+
+	if len(prop.Variants) > 0 {
+
+		new_prop.Variants = make([]*ColoringBookProperty, len(prop.Variants))
+
+		for i, v := range prop.Variants {
+
+			if v == nil {
+				continue
+			}
+
+			new_prop.Variants[i] = preserve(v)
+		}
+	}
+
+	return new_prop
+}
+
+// AssignColoringBookOptions assigns 'prop' to the "millsfield:coloring_book_options" property of 'body'. It returns a
+// boolean value indicating whether 'body' was changed and the updated body.
+func AssignColoringBookOptions(ctx context.Context, body []byte, prop *OutlineProperty) (bool, []byte, error) {
+
+	prop_value, err := jsonValue(prop)
+
+	if err != nil {
+		return false, nil, err
+	}
+
+	updates := map[string]interface{}{
+		COLORING_BOOK_OPTIONS_PROPERTY: prop_value,
+	}
+
+	has_updates, new_body, err := export.AssignPropertiesIfChanged(ctx, body, updates)
+
+	if err != nil {
+		return false, nil, fmt.Errorf("Failed to assign updates to object record, %w", err)
+	}
+
+	return has_updates, new_body, nil
+}
+
+// RemoveColoringBookProperties removes all the coloring book related properties, including any per-object outline
+// options, from 'body'. It returns a boolean value indicating whether any properties were removed and the updated body.
+func RemoveColoringBookProperties(ctx context.Context, body []byte) (bool, []byte, error) {
+
+	to_remove := []string{
+		HAS_COLORING_BOOK_PROPERTY,
+		COLORING_BOOK_PROPERTY,
+		COLORING_BOOK_OPTIONS_PROPERTY,
+	}
+
+	has_updates := false
+
+	for _, path := range to_remove {
+
+		if gjson.GetBytes(body, path).Exists() {
+			has_updates = true
+			break
+		}
+	}
+
+	if !has_updates {
+		return false, body, nil
+	}
+
+	new_body, err := export.RemoveProperties(ctx, body, to_remove)
+
+	if err != nil {
+		return false, nil, fmt.Errorf("Failed to remove properties, %w", err)
+	}
+
+	return true, new_body, nil
+}
+
+// NewObjectRecordWriter returns a new `whosonfirst/go-writer/v3.Writer` instance derived from 'writer_uri'. If
+// 'access_token_uri' is not empty it will be used to ensure that 'writer_uri' contains a valid GitHub access token.
+func NewObjectRecordWriter(ctx context.Context, writer_uri string, access_token_uri string) (writer.Writer, error) {
+
+	if access_token_uri != "" {
+
+		uri, err := gh_writer.EnsureGitHubAccessToken(ctx, writer_uri, access_token_uri)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to ensure access token, %w", err)
+		}
+
+		writer_uri = uri
+	}
+
+	wr, err := writer.NewWriter(ctx, writer_uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create new writer, %w", err)
+	}
+
+	return wr, nil
+}
+
+// WriteObjectRecord writes 'body' using the `whosonfirst/go-writer/v3.Writer` instance derived from 'writer_uri'
+// and then closes the writer. If 'access_token_uri' is not empty it will be used to ensure that 'writer_uri'
+// contains a valid GitHub access token.
+func WriteObjectRecord(ctx context.Context, writer_uri string, access_token_uri string, body []byte) error {
+
+	wr, err := NewObjectRecordWriter(ctx, writer_uri, access_token_uri)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = sfom_writer.WriteBytes(ctx, wr, body)
+
+	if err != nil {
+		return fmt.Errorf("Failed to write object record, %w", err)
+	}
+
+	err = wr.Close(ctx)
+
+	if err != nil {
+		return fmt.Errorf("Failed to close object record writer, %w", err)
+	}
+
+	return nil
+}
+
+// PullRequestWriterURI converts 'writer_uri', which is expected to be a `githubapi://` or `githubapi-pr://` URI,
+// in to a `githubapi-pr://` URI which will gather all the records written to it on the branch 'pr_branch' and
+// open a single pull request, titled 'pr_title', when the writer is closed. Any "pr-branch", "pr-title" or
+// "pr-description" parameters already present in 'writer_uri' are preserved.
+func PullRequestWriterURI(writer_uri string, pr_branch string, pr_title string, pr_description string) (string, error) {
+
+	u, err := url.Parse(writer_uri)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse writer URI, %w", err)
+	}
+
+	switch u.Scheme {
+	case gh_writer.GITHUBAPI_PR_SCHEME:
+		// pass
+	case gh_writer.GITHUBAPI_SCHEME:
+		u.Scheme = gh_writer.GITHUBAPI_PR_SCHEME
+	default:
+		return "", fmt.Errorf("Unsupported writer URI scheme '%s', expected githubapi:// or githubapi-pr://", u.Scheme)
+	}
+
+	q := u.Query()
+
+	// These are specific to the githubapi:// writer
+	q.Del("new")
+	q.Del("update")
+
+	defaults := map[string]string{
+		"pr-branch":      pr_branch,
+		"pr-title":       pr_title,
+		"pr-description": pr_description,
+	}
+
+	for k, v := range defaults {
+
+		if q.Get(k) == "" && v != "" {
+			q.Set(k, v)
+		}
+	}
+
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,43 @@
+package coloringbook
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestRemoveColoringBookProperties(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		body    string
+		updated bool
+	}{
+		{"all", `{"properties":{"millsfield:has_coloring_book":1,"millsfield:coloring_book":{"pdf":"1234-5678.pdf"},"millsfield:coloring_book_options":{"contour":{}}}}`, true},
+		{"options only", `{"properties":{"millsfield:coloring_book_options":{"contour":{}}}}`, true},
+		{"none", `{"properties":{"wof:name":"test"}}`, false},
+	}
+
+	for _, test := range tests {
+
+		updated, body, err := RemoveColoringBookProperties(ctx, []byte(test.body))
+
+		if err != nil {
+			t.Fatalf("Failed to remove properties for %s, %v", test.name, err)
+		}
+
+		if updated != test.updated {
+			t.Fatalf("Expected updated to be %t for %s, got %t", test.updated, test.name, updated)
+		}
+
+		for _, path := range []string{HAS_COLORING_BOOK_PROPERTY, COLORING_BOOK_PROPERTY, COLORING_BOOK_OPTIONS_PROPERTY} {
+
+			if gjson.GetBytes(body, path).Exists() {
+				t.Fatalf("Expected %s to be removed for %s", path, test.name)
+			}
+		}
+	}
+}
@@ -1,13 +1,14 @@
 package coloringbook
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"image"
 	"image/png"
 	"io"
 	"log"
-	"os"
+	"math"
 
 	"github.com/boombuler/barcode/qr"
 	"github.com/go-pdf/fpdf"
@@ -17,10 +18,29 @@ import (
 	"github.com/sfomuseum/go-sfomuseum-coloringbook/static"
 )
 
+// PAGE_SIZE is the page size that AddSheet lays out sheets for.
+const PAGE_SIZE string = "Letter"
+
+// SHEET_DPI is the resolution, in pixels per inch, at which AddSheet lays out images that fit in the printable area.
+const SHEET_DPI float64 = 150.0
+
+const sheet_letter_w float64 = 8.5
+const sheet_letter_h float64 = 11.0
+const sheet_margin_x float64 = 0.5
+const sheet_margin_y float64 = 0.5
+
+// The name used to register the SFO Museum logo with a PDF document.
+const sheet_logo_name string = "logo-150.png"
+
 type AddSheetOptions struct {
-	Image           image.Image
-	ImageReader     io.Reader
-	ImagePath       string
+	// The (outline) image to lay out on the sheet.
+	Image image.Image
+	// An optional reader containing the PNG-encoded bytes of Image. If nil, or if Image needs to be resized to fit
+	// the page, Image is encoded in memory.
+	ImageReader io.Reader
+	// The name used to register Image with the PDF document. It must be unique within the document. If empty a name
+	// is derived from the page number.
+	ImageName       string
 	Title           string
 	Date            string
 	CreditLine      string
@@ -29,21 +49,72 @@ type AddSheetOptions struct {
 	Outline         *outline.OutlineOptions
 }
 
-func AddSheet(ctx context.Context, pdf *fpdf.Fpdf, opts *AddSheetOptions) error {
+// PrintableArea returns the maximum width and height, in inches, of an image laid out by AddSheet on a page
+// with 'orientation' ("P" or "L").
+func PrintableArea(orientation string) (float64, float64) {
+
+	if orientation == "P" {
+		return sheet_letter_w - (sheet_margin_x * 2), sheet_letter_h - (sheet_margin_y * 3.75)
+	}
+
+	return sheet_letter_h - (sheet_margin_x * 2), sheet_letter_w - (sheet_margin_y * 3.75)
+}
+
+// PrintedPixelsPerInch returns the number of pixels in 'im' per printed inch once it has been laid out by AddSheet.
+// Images larger than the printable area are scaled down to fit it so they will have more than SHEET_DPI pixels per inch.
+func PrintedPixelsPerInch(im image.Image) float64 {
+
+	max_w, max_h := PrintableArea(Orientation(im))
+
+	dims := im.Bounds()
+	im_w := float64(dims.Dx()) / SHEET_DPI
+	im_h := float64(dims.Dy()) / SHEET_DPI
+
+	if im_w <= max_w && im_h <= max_h {
+		return SHEET_DPI
+	}
+
+	scale := math.Min(max_w/im_w, max_h/im_h)
+	return SHEET_DPI / scale
+}
 
-	letter_w := 8.5
-	letter_h := 11.0
+// EnlargeToPrintableArea returns a copy of 'im' scaled up, preserving its aspect ratio, so that it fills the printable
+// area of a sheet at SHEET_DPI. Images which already fill the printable area in either dimension are returned unchanged.
+func EnlargeToPrintableArea(im image.Image) image.Image {
+
+	max_w, max_h := PrintableArea(Orientation(im))
+
+	dims := im.Bounds()
+	im_w := float64(dims.Dx()) / SHEET_DPI
+	im_h := float64(dims.Dy()) / SHEET_DPI
+
+	if im_w == 0 || im_h == 0 || im_w >= max_w || im_h >= max_h {
+		return im
+	}
+
+	scale := math.Min(max_w/im_w, max_h/im_h)
+
+	// Round down so that the enlarged image never exceeds the printable area
+	new_w := uint(math.Floor(float64(dims.Dx()) * scale))
+	new_h := uint(math.Floor(float64(dims.Dy()) * scale))
+
+	return resize.Resize(new_w, new_h, im, resize.Lanczos3)
+}
+
+// AddSheet lays out the image, caption and QR code defined by 'opts' on a new page in 'pdf'. Images are registered with
+// 'pdf' from memory; nothing is written to disk.
+func AddSheet(ctx context.Context, pdf *fpdf.Fpdf, opts *AddSheetOptions) error {
 
 	logo_w := 1.0
 	logo_h := 0.3
 
-	dpi := 150.0
+	dpi := SHEET_DPI
 
-	margin_x := 0.5
-	margin_y := 0.5
+	margin_x := sheet_margin_x
+	margin_y := sheet_margin_y
 
-	max_w := letter_h - (margin_x * 2)
-	max_h := letter_w - (margin_y * 3.75)
+	orientation := Orientation(opts.Image)
+	max_w, max_h := PrintableArea(orientation)
 
 	footer_y := margin_y + max_h + 0.15
 
@@ -53,13 +124,19 @@ func AddSheet(ctx context.Context, pdf *fpdf.Fpdf, opts *AddSheetOptions) error
 
 	line_h := 0.15
 
-	if Orientation(opts.Image) == "P" {
-		max_h = letter_h - (margin_y * 3.75)
-		max_w = letter_w - (margin_x * 2)
+	if orientation == "P" {
 		footer_y = margin_y + max_h + 0.1
 	}
 
-	dims := opts.Image.Bounds()
+	im := opts.Image
+	im_reader := opts.ImageReader
+	im_name := opts.ImageName
+
+	if im_name == "" {
+		im_name = fmt.Sprintf("sheet-%d.png", pdf.PageCount()+1)
+	}
+
+	dims := im.Bounds()
 	im_w := float64(dims.Max.X) / dpi
 	im_h := float64(dims.Max.Y) / dpi
 
@@ -74,47 +151,29 @@ func AddSheet(ctx context.Context, pdf *fpdf.Fpdf, opts *AddSheetOptions) error
 		new_w := uint(max_w * dpi)
 		new_h := uint(max_h * dpi)
 
-		new_im := resize.Thumbnail(new_w, new_h, opts.Image, resize.Lanczos3)
-		bounds := new_im.Bounds()
+		im = resize.Thumbnail(new_w, new_h, im, resize.Lanczos3)
+		bounds := im.Bounds()
 
 		log.Printf("NEW DIMS w %d (%02f) %d (%02f)\n", bounds.Max.X, float64(bounds.Max.X)/dpi, bounds.Max.Y, float64(bounds.Max.Y)/dpi)
 
-		resized_fh, err := os.CreateTemp("", "*.png")
-
-		if err != nil {
-			return fmt.Errorf("Failed to create resized temp file, %w", err)
-		}
+		// The original bytes no longer match the image
+		im_reader = nil
 
-		resized_path := resized_fh.Name()
-		defer os.Remove(resized_path)
-
-		err = png.Encode(resized_fh, new_im)
-
-		if err != nil {
-			return fmt.Errorf("Failed to write resized image, %w", err)
-		}
+		im_w = float64(bounds.Max.X) / dpi
+		im_h = float64(bounds.Max.Y) / dpi
+	}
 
-		err = resized_fh.Close()
+	if im_reader == nil {
 
-		if err != nil {
-			return fmt.Errorf("Failed to close resized image after writing, %w", err)
-		}
+		var buf bytes.Buffer
 
-		resized_r, err := os.Open(resized_path)
+		err := png.Encode(&buf, im)
 
 		if err != nil {
-			return fmt.Errorf("Failed to open %s for reading, %w", resized_path, err)
+			return fmt.Errorf("Failed to encode image, %w", err)
 		}
 
-		defer resized_r.Close()
-
-		opts.Image = new_im
-		opts.ImagePath = resized_path
-		opts.ImageReader = resized_r
-
-		new_dims := new_im.Bounds()
-		im_w = float64(new_dims.Max.X) / dpi
-		im_h = float64(new_dims.Max.Y) / dpi
+		im_reader = &buf
 	}
 
 	log.Printf("IMAGE w %02f h %02f\n", im_w, im_h)
@@ -141,10 +200,15 @@ func AddSheet(ctx context.Context, pdf *fpdf.Fpdf, opts *AddSheetOptions) error
 		ReadDpi:   false,
 	}
 
-	info := pdf.RegisterImageOptionsReader(opts.ImagePath, im_opts, opts.ImageReader)
+	info := pdf.RegisterImageOptionsReader(im_name, im_opts, im_reader)
+
+	if pdf.Err() {
+		return fmt.Errorf("Failed to register image, %w", pdf.Error())
+	}
+
 	info.SetDpi(dpi)
 
-	pdf.ImageOptions(opts.ImagePath, im_x, im_y, im_w, im_h, false, im_opts, 0, "")
+	pdf.ImageOptions(im_name, im_x, im_y, im_w, im_h, false, im_opts, 0, "")
 
 	// QR code
 
@@ -168,50 +232,31 @@ func AddSheet(ctx context.Context, pdf *fpdf.Fpdf, opts *AddSheetOptions) error
 
 	// Add SFO Museum logo
 
-	logo_r, err := static.FS.Open("logo-150.png")
-
-	if err != nil {
-		return fmt.Errorf("Failed to open SFOM logo, %w", err)
-	}
-
-	defer logo_r.Close()
-
-	logo_tmpfile, err := os.CreateTemp("", "*.png")
-
-	if err != nil {
-		return fmt.Errorf("Failed to create logo temp file, %w", err)
+	logo_opts := fpdf.ImageOptions{
+		ImageType: "png",
+		ReadDpi:   false,
 	}
 
-	logo_path := logo_tmpfile.Name()
-	defer os.Remove(logo_path)
+	// The logo only needs to be registered once per document
 
-	_, err = io.Copy(logo_tmpfile, logo_r)
+	if pdf.GetImageInfo(sheet_logo_name) == nil {
 
-	if err != nil {
-		return fmt.Errorf("Failed to copy logo, %w", err)
-	}
-
-	err = logo_tmpfile.Close()
+		logo_body, err := static.FS.ReadFile(sheet_logo_name)
 
-	if err != nil {
-		return fmt.Errorf("Failed to close logo temp file, %w", err)
-	}
+		if err != nil {
+			return fmt.Errorf("Failed to read SFOM logo, %w", err)
+		}
 
-	logo_opts := fpdf.ImageOptions{
-		ImageType: "png",
-		ReadDpi:   false,
-	}
+		logo_info := pdf.RegisterImageOptionsReader(sheet_logo_name, logo_opts, bytes.NewReader(logo_body))
 
-	logo_r, err = os.Open(logo_path)
+		if pdf.Err() {
+			return fmt.Errorf("Failed to register SFOM logo, %w", pdf.Error())
+		}
 
-	if err != nil {
-		return fmt.Errorf("Failed to open logo PNG file %s, %w", logo_path, err)
+		logo_info.SetDpi(150)
 	}
 
-	logo_info := pdf.RegisterImageOptionsReader(logo_path, logo_opts, logo_r)
-	logo_info.SetDpi(150)
-
-	pdf.ImageOptions(logo_path, (margin_x+max_w)-logo_w, footer_y, logo_w, logo_h, false, logo_opts, 0, "")
+	pdf.ImageOptions(sheet_logo_name, (margin_x+max_w)-logo_w, footer_y, logo_w, logo_h, false, logo_opts, 0, "")
 
 	return nil
 }
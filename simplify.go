@@ -0,0 +1,607 @@
+package coloringbook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sfomuseum/go-coloringbook/outline"
+)
+
+// DEFAULT_SIMPLIFY_TOLERANCE is the default maximum distance, in pixels, that a simplified path may deviate from the original path.
+// The default is 0, meaning that paths are not simplified.
+const DEFAULT_SIMPLIFY_TOLERANCE float64 = 0.0
+
+// smoothed paths keep sharp corners where they turn by more than this angle (in radians).
+const smooth_max_angle float64 = math.Pi / 3
+
+var re_svg_path = regexp.MustCompile(`(?s)<path\b[^>]*?/?>(?:\s*</path>)?`)
+var re_svg_path_data = regexp.MustCompile(`(\sd=")([^"]*)(")`)
+
+// SimplifyOptions defines configuration options for simplifying the paths in SVG outlines.
+type SimplifyOptions struct {
+	// The maximum distance, in pixels, that a simplified path may deviate from the original path.
+	Tolerance float64
+	// A boolean flag indicating that simplified paths should be drawn as smooth (cubic Bézier) curves rather than straight line segments.
+	Smooth bool
+	// The minimum length, in pixels, of a path. Shorter paths are removed. Paths with fewer than two distinct points are always removed.
+	MinLength float64
+}
+
+type svgPoint struct {
+	X float64
+	Y float64
+}
+
+// svgSubpath is a single (absolute) polyline from an SVG path's data.
+type svgSubpath struct {
+	points []svgPoint
+	closed bool
+}
+
+// svgOutline is an `outline.Outline` implementation for SVG documents.
+type svgOutline struct {
+	svg []byte
+}
+
+var _ outline.Outline = (*svgOutline)(nil)
+
+func (o *svgOutline) Write(ctx context.Context, wr io.Writer) error {
+	_, err := wr.Write(o.svg)
+	return err
+}
+
+// SimplifyOutline returns a copy of 'o' with its paths simplified according to 'opts', using SimplifySVG. Raster
+// (non-SVG) outlines are returned unchanged.
+func SimplifyOutline(ctx context.Context, o outline.Outline, opts *SimplifyOptions) (outline.Outline, error) {
+
+	// Don't encode raster outlines only to discover that they aren't SVG documents
+
+	switch t := o.(type) {
+	case *imageOutline, *outline.PNGOutline:
+		return o, nil
+	case *svgOutline:
+		return &svgOutline{svg: SimplifySVG(t.svg, opts)}, nil
+	}
+
+	var buf bytes.Buffer
+
+	err := o.Write(ctx, &buf)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to write outline, %w", err)
+	}
+
+	body := buf.Bytes()
+
+	if !isSVG(body) {
+		return o, nil
+	}
+
+	simplified := SimplifySVG(body, opts)
+
+	return &svgOutline{svg: simplified}, nil
+}
+
+// SimplifySVG returns a copy of the SVG document 'body' whose paths have been simplified (using the Douglas-Peucker algorithm)
+// to the tolerance defined in 'opts' and, optionally, smoothed. Degenerate paths are removed. Only paths made up of (absolute
+// or relative) move, line and close commands are simplified; all other paths are left unchanged.
+func SimplifySVG(body []byte, opts *SimplifyOptions) []byte {
+
+	return re_svg_path.ReplaceAllFunc(body, func(el []byte) []byte {
+
+		m := re_svg_path_data.FindSubmatchIndex(el)
+
+		if m == nil {
+			return el
+		}
+
+		d := string(el[m[4]:m[5]])
+		subpaths, ok := parsePathData(d)
+
+		if !ok {
+			return el
+		}
+
+		new_d := simplifySubpaths(subpaths, opts)
+
+		if new_d == "" {
+			return []byte{}
+		}
+
+		new_el := make([]byte, 0, len(el))
+		new_el = append(new_el, el[:m[4]]...)
+		new_el = append(new_el, new_d...)
+		new_el = append(new_el, el[m[5]:]...)
+
+		return new_el
+	})
+}
+
+func isSVG(body []byte) bool {
+
+	head := body
+
+	if len(head) > 512 {
+		head = head[:512]
+	}
+
+	return bytes.Contains(head, []byte("<svg"))
+}
+
+// parsePathData parses the SVG path data 'd' into a list of absolute polylines. It returns false if 'd' contains
+// commands other than M, L, H, V and Z (or their relative equivalents) or is malformed.
+func parsePathData(d string) ([]*svgSubpath, bool) {
+
+	tokens := tokenizePathData(d)
+
+	subpaths := make([]*svgSubpath, 0)
+
+	var current *svgSubpath
+	var pos svgPoint
+	var start svgPoint
+
+	cmd := ""
+
+	next_number := func(i int) (float64, bool) {
+
+		if i >= len(tokens) {
+			return 0, false
+		}
+
+		v, err := strconv.ParseFloat(tokens[i], 64)
+
+		if err != nil {
+			return 0, false
+		}
+
+		return v, true
+	}
+
+	i := 0
+
+	for i < len(tokens) {
+
+		t := tokens[i]
+
+		if isPathCommand(t) {
+			cmd = t
+			i += 1
+		} else if cmd == "" {
+			return nil, false
+		}
+
+		switch cmd {
+		case "M", "m", "L", "l":
+
+			x, ok_x := next_number(i)
+			y, ok_y := next_number(i + 1)
+
+			if !ok_x || !ok_y {
+				return nil, false
+			}
+
+			i += 2
+
+			if cmd == "m" || cmd == "l" {
+				x += pos.X
+				y += pos.Y
+			}
+
+			pos = svgPoint{x, y}
+
+			if cmd == "M" || cmd == "m" {
+
+				current = &svgSubpath{points: []svgPoint{pos}}
+				subpaths = append(subpaths, current)
+				start = pos
+
+				// Subsequent coordinate pairs are implicit line commands
+				if cmd == "M" {
+					cmd = "L"
+				} else {
+					cmd = "l"
+				}
+
+				continue
+			}
+
+		case "H", "h", "V", "v":
+
+			v, ok := next_number(i)
+
+			if !ok {
+				return nil, false
+			}
+
+			i += 1
+
+			switch cmd {
+			case "H":
+				pos.X = v
+			case "h":
+				pos.X += v
+			case "V":
+				pos.Y = v
+			case "v":
+				pos.Y += v
+			}
+
+		case "Z", "z":
+
+			if current == nil {
+				return nil, false
+			}
+
+			current.closed = true
+			pos = start
+			current = nil
+			cmd = ""
+			continue
+
+		default:
+			return nil, false
+		}
+
+		if current == nil {
+			current = &svgSubpath{points: []svgPoint{start}}
+			subpaths = append(subpaths, current)
+		}
+
+		current.points = append(current.points, pos)
+	}
+
+	return subpaths, true
+}
+
+func isPathCommand(t string) bool {
+	return len(t) == 1 && strings.Contains("MmLlHhVvZzCcSsQqTtAa", t)
+}
+
+// tokenizePathData splits the SVG path data 'd' into commands and numbers.
+func tokenizePathData(d string) []string {
+
+	tokens := make([]string, 0)
+	var sb strings.Builder
+
+	flush := func() {
+
+		if sb.Len() > 0 {
+			tokens = append(tokens, sb.String())
+			sb.Reset()
+		}
+	}
+
+	runes := []rune(d)
+
+	for i, r := range runes {
+
+		switch {
+		case r == ' ' || r == ',' || r == '\t' || r == '\n' || r == '\r':
+			flush()
+		case r == '-' || r == '+':
+
+			// A sign starts a new number unless it follows an exponent
+			if i > 0 && (runes[i-1] == 'e' || runes[i-1] == 'E') {
+				sb.WriteRune(r)
+			} else {
+				flush()
+				sb.WriteRune(r)
+			}
+
+		case r == '.' && strings.Contains(sb.String(), "."):
+
+			// A second decimal point starts a new number, for example "1.5.5"
+			flush()
+			sb.WriteRune(r)
+
+		case (r == 'e' || r == 'E') && sb.Len() > 0:
+			sb.WriteRune(r)
+		case (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z'):
+			flush()
+			tokens = append(tokens, string(r))
+		default:
+			sb.WriteRune(r)
+		}
+	}
+
+	flush()
+	return tokens
+}
+
+// simplifySubpaths returns the path data for 'subpaths' simplified according to 'opts'. Degenerate subpaths are omitted.
+func simplifySubpaths(subpaths []*svgSubpath, opts *SimplifyOptions) string {
+
+	parts := make([]string, 0)
+
+	for _, sp := range subpaths {
+
+		points := dedupePoints(sp.points)
+
+		if sp.closed && len(points) > 1 && points[0] == points[len(points)-1] {
+			points = points[:len(points)-1]
+		}
+
+		if len(points) < 2 {
+			continue
+		}
+
+		if pathLength(points, sp.closed) <= math.Max(opts.MinLength, 0) {
+			continue
+		}
+
+		if sp.closed {
+			points = simplifyRing(points, opts.Tolerance)
+		} else {
+			points = douglasPeucker(points, opts.Tolerance)
+		}
+
+		if len(points) < 2 {
+			continue
+		}
+
+		if opts.Smooth && len(points) > 2 {
+			parts = append(parts, smoothPathData(points, sp.closed))
+		} else {
+			parts = append(parts, linearPathData(points, sp.closed))
+		}
+	}
+
+	return strings.Join(parts, "")
+}
+
+// dedupePoints removes consecutive duplicate points from 'points'.
+func dedupePoints(points []svgPoint) []svgPoint {
+
+	deduped := make([]svgPoint, 0, len(points))
+
+	for _, pt := range points {
+
+		if len(deduped) > 0 && deduped[len(deduped)-1] == pt {
+			continue
+		}
+
+		deduped = append(deduped, pt)
+	}
+
+	return deduped
+}
+
+func pathLength(points []svgPoint, closed bool) float64 {
+
+	length := 0.0
+
+	for i := 1; i < len(points); i++ {
+		length += math.Hypot(points[i].X-points[i-1].X, points[i].Y-points[i-1].Y)
+	}
+
+	if closed && len(points) > 2 {
+		last := points[len(points)-1]
+		length += math.Hypot(points[0].X-last.X, points[0].Y-last.Y)
+	}
+
+	return length
+}
+
+// simplifyRing simplifies the closed polyline 'points' by splitting it at the point furthest from its first point and
+// simplifying each half using the Douglas-Peucker algorithm.
+func simplifyRing(points []svgPoint, tolerance float64) []svgPoint {
+
+	if len(points) < 4 {
+		return points
+	}
+
+	far := 0
+	far_d := -1.0
+
+	for i, pt := range points {
+
+		d := math.Hypot(pt.X-points[0].X, pt.Y-points[0].Y)
+
+		if d > far_d {
+			far = i
+			far_d = d
+		}
+	}
+
+	first := append([]svgPoint{}, points[:far+1]...)
+	second := append(append([]svgPoint{}, points[far:]...), points[0])
+
+	a := douglasPeucker(first, tolerance)
+	b := douglasPeucker(second, tolerance)
+
+	// Both halves share the split point and 'b' ends with the first point of 'a'
+	ring := append(a, b[1:len(b)-1]...)
+
+	if len(ring) < 3 {
+		return points[:1]
+	}
+
+	return ring
+}
+
+// douglasPeucker simplifies the open polyline 'points' using the Douglas-Peucker algorithm so that no point
+// is removed which is more than 'tolerance' from the simplified line.
+func douglasPeucker(points []svgPoint, tolerance float64) []svgPoint {
+
+	if len(points) < 3 || tolerance <= 0 {
+		return points
+	}
+
+	keep := make([]bool, len(points))
+	keep[0] = true
+	keep[len(points)-1] = true
+
+	stack := [][2]int{{0, len(points) - 1}}
+
+	for len(stack) > 0 {
+
+		span := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		first := span[0]
+		last := span[1]
+
+		max_d := 0.0
+		index := -1
+
+		for i := first + 1; i < last; i++ {
+
+			d := segmentDistance(points[i], points[first], points[last])
+
+			if d > max_d {
+				max_d = d
+				index = i
+			}
+		}
+
+		if index != -1 && max_d > tolerance {
+			keep[index] = true
+			stack = append(stack, [2]int{first, index}, [2]int{index, last})
+		}
+	}
+
+	simplified := make([]svgPoint, 0)
+
+	for i, pt := range points {
+
+		if keep[i] {
+			simplified = append(simplified, pt)
+		}
+	}
+
+	return simplified
+}
+
+// segmentDistance returns the distance from 'pt' to the line segment 'a' - 'b'.
+func segmentDistance(pt svgPoint, a svgPoint, b svgPoint) float64 {
+
+	dx := b.X - a.X
+	dy := b.Y - a.Y
+
+	if dx == 0 && dy == 0 {
+		return math.Hypot(pt.X-a.X, pt.Y-a.Y)
+	}
+
+	t := ((pt.X-a.X)*dx + (pt.Y-a.Y)*dy) / (dx*dx + dy*dy)
+	t = math.Max(0, math.Min(1, t))
+
+	return math.Hypot(pt.X-(a.X+t*dx), pt.Y-(a.Y+t*dy))
+}
+
+func linearPathData(points []svgPoint, closed bool) string {
+
+	var sb strings.Builder
+
+	for i, pt := range points {
+
+		if i == 0 {
+			sb.WriteString("M")
+		} else {
+			sb.WriteString("L")
+		}
+
+		sb.WriteString(formatPoint(pt))
+	}
+
+	if closed {
+		sb.WriteString("Z")
+	}
+
+	return sb.String()
+}
+
+// smoothPathData returns path data drawing a Catmull-Rom spline through 'points' as a series of cubic Bézier curves.
+// Points where the path turns by more than smooth_max_angle are kept as sharp corners.
+func smoothPathData(points []svgPoint, closed bool) string {
+
+	n := len(points)
+
+	at := func(i int) svgPoint {
+
+		if closed {
+			return points[((i%n)+n)%n]
+		}
+
+		return points[int(math.Max(0, math.Min(float64(n-1), float64(i))))]
+	}
+
+	// tangent returns the (scaled) tangent of the spline at point 'i', or a zero tangent for corners and the ends of open paths
+	tangent := func(i int) svgPoint {
+
+		if !closed && (i <= 0 || i >= n-1) {
+			return svgPoint{}
+		}
+
+		prev := at(i - 1)
+		pt := at(i)
+		next := at(i + 1)
+
+		a1 := math.Atan2(pt.Y-prev.Y, pt.X-prev.X)
+		a2 := math.Atan2(next.Y-pt.Y, next.X-pt.X)
+
+		turn := math.Abs(math.Remainder(a2-a1, 2*math.Pi))
+
+		if turn > smooth_max_angle {
+			return svgPoint{}
+		}
+
+		return svgPoint{(next.X - prev.X) / 6, (next.Y - prev.Y) / 6}
+	}
+
+	segments := n - 1
+
+	if closed {
+		segments = n
+	}
+
+	var sb strings.Builder
+
+	sb.WriteString("M")
+	sb.WriteString(formatPoint(points[0]))
+
+	for i := 0; i < segments; i++ {
+
+		p1 := at(i)
+		p2 := at(i + 1)
+
+		t1 := tangent(i)
+		t2 := tangent(i + 1)
+
+		c1 := svgPoint{p1.X + t1.X, p1.Y + t1.Y}
+		c2 := svgPoint{p2.X - t2.X, p2.Y - t2.Y}
+
+		sb.WriteString("C")
+		sb.WriteString(formatPoint(c1))
+		sb.WriteString(" ")
+		sb.WriteString(formatPoint(c2))
+		sb.WriteString(" ")
+		sb.WriteString(formatPoint(p2))
+	}
+
+	if closed {
+		sb.WriteString("Z")
+	}
+
+	return sb.String()
+}
+
+func formatPoint(pt svgPoint) string {
+	return formatCoordinate(pt.X) + "," + formatCoordinate(pt.Y)
+}
+
+func formatCoordinate(v float64) string {
+
+	v = math.Round(v*100) / 100
+
+	if v == 0 {
+		v = 0 // avoid "-0"
+	}
+
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,150 @@
+package coloringbook
+
+import (
+	"context"
+	"image/color"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTokenizePathData(t *testing.T) {
+
+	tests := []struct {
+		d        string
+		expected []string
+	}{
+		{"", []string{}},
+		{"M0 0L10 10Z", []string{"M", "0", "0", "L", "10", "10", "Z"}},
+		{"M 1,2 l-3-4", []string{"M", "1", "2", "l", "-3", "-4"}},
+		{"M1.5.5L2e-3,4E+2", []string{"M", "1.5", ".5", "L", "2e-3", "4E+2"}},
+		{"m0\t0\nh10v-5z", []string{"m", "0", "0", "h", "10", "v", "-5", "z"}},
+	}
+
+	for _, test := range tests {
+
+		tokens := tokenizePathData(test.d)
+
+		if !reflect.DeepEqual(tokens, test.expected) {
+			t.Errorf("Unexpected tokens for '%s', expected %q got %q", test.d, test.expected, tokens)
+		}
+	}
+}
+
+func TestDouglasPeucker(t *testing.T) {
+
+	tests := []struct {
+		points    []svgPoint
+		tolerance float64
+		expected  []svgPoint
+	}{
+		{
+			[]svgPoint{{0, 0}, {5, 0.1}, {10, 0}},
+			1.0,
+			[]svgPoint{{0, 0}, {10, 0}},
+		},
+		{
+			[]svgPoint{{0, 0}, {5, 3}, {10, 0}},
+			1.0,
+			[]svgPoint{{0, 0}, {5, 3}, {10, 0}},
+		},
+		{
+			[]svgPoint{{0, 0}, {1, 0.5}, {2, 0}, {3, 0.5}, {4, 0}, {5, 10}, {6, 0}},
+			1.0,
+			[]svgPoint{{0, 0}, {4, 0}, {5, 10}, {6, 0}},
+		},
+		{
+			[]svgPoint{{0, 0}, {5, 0.1}, {10, 0}},
+			0,
+			[]svgPoint{{0, 0}, {5, 0.1}, {10, 0}},
+		},
+		{
+			[]svgPoint{{0, 0}, {10, 0}},
+			1.0,
+			[]svgPoint{{0, 0}, {10, 0}},
+		},
+	}
+
+	for i, test := range tests {
+
+		simplified := douglasPeucker(test.points, test.tolerance)
+
+		if !reflect.DeepEqual(simplified, test.expected) {
+			t.Errorf("Unexpected points for test %d, expected %v got %v", i, test.expected, simplified)
+		}
+	}
+}
+
+func TestSimplifySVG(t *testing.T) {
+
+	body := []byte(`<svg><path d="M0 0 L5 0.1 L10 0" stroke="black"/><path d="M1 1 L1 1"/><path d="M0 0 C1 1 2 2 3 3"/></svg>`)
+
+	simplified := string(SimplifySVG(body, &SimplifyOptions{Tolerance: 1.0}))
+
+	if strings.Contains(simplified, "5") {
+		t.Errorf("Expected intermediate point to be removed, %s", simplified)
+	}
+
+	if strings.Contains(simplified, `d="M1 1`) {
+		t.Errorf("Expected degenerate path to be removed, %s", simplified)
+	}
+
+	if !strings.Contains(simplified, `d="M0 0 C1 1 2 2 3 3"`) {
+		t.Errorf("Expected curved path to be left unchanged, %s", simplified)
+	}
+
+	if !strings.Contains(simplified, `stroke="black"`) {
+		t.Errorf("Expected path attributes to be preserved, %s", simplified)
+	}
+}
+
+func TestSimplifyOutlineImage(t *testing.T) {
+
+	ctx := context.Background()
+
+	// A square drawn with one line segment per pixel, as produced by contouring
+
+	d := "M10 10"
+
+	for x := 11; x <= 30; x++ {
+		d += " L" + strconv.Itoa(x) + " 10"
+	}
+
+	d += " L30 30 L10 30 Z"
+
+	body := `<svg xmlns="http://www.w3.org/2000/svg" width="40" height="40" viewBox="0 0 40 40">` +
+		`<rect width="40" height="40" fill="white"/>` +
+		`<path d="` + d + `" fill="none" stroke="black" stroke-width="2"/></svg>`
+
+	o, err := SimplifyOutline(ctx, &svgOutline{svg: []byte(body)}, &SimplifyOptions{Tolerance: 1.0})
+
+	if err != nil {
+		t.Fatalf("Failed to simplify outline, %v", err)
+	}
+
+	simplified := string(o.(*svgOutline).svg)
+
+	if strings.Count(simplified, "L") >= strings.Count(body, "L") {
+		t.Fatalf("Expected simplified outline to contain fewer line segments, got %s", simplified)
+	}
+
+	// Simplified SVG outlines are rasterized before they are embedded in sheets
+
+	im, err := OutlineImage(ctx, o)
+
+	if err != nil {
+		t.Fatalf("Failed to rasterize outline, %v", err)
+	}
+
+	if im.Bounds().Dx() != 40 || im.Bounds().Dy() != 40 {
+		t.Fatalf("Expected 40x40 image, got %v", im.Bounds())
+	}
+
+	edge := color.GrayModel.Convert(im.At(20, 10)).(color.Gray)
+	inside := color.GrayModel.Convert(im.At(20, 20)).(color.Gray)
+
+	if edge.Y > 0x80 || inside.Y < 0x80 {
+		t.Fatalf("Expected rasterized outline to have a black edge and white interior, got %v and %v", edge, inside)
+	}
+}
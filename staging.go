@@ -0,0 +1,214 @@
+package coloringbook
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+)
+
+// ReviewOptions defines the buckets used to review coloring book sheets before they are published.
+type ReviewOptions struct {
+	// The bucket where sheets awaiting review have been written.
+	StagingBucket *blob.Bucket
+	// The (public) bucket where approved sheets are published.
+	Bucket *blob.Bucket
+	// The attributes to assign to files when they are copied to Bucket.
+	PublishOptions *PublishOptions
+	// A boolean flag indicating whether files are stored using a Who's On First -style tree.
+	AppendTree bool
+}
+
+// ListPending returns the manifests for all the sheets in 'bucket' which are awaiting review.
+func ListPending(ctx context.Context, bucket *blob.Bucket) ([]*Manifest, error) {
+
+	pending := make([]*Manifest, 0)
+
+	iter := bucket.List(&blob.ListOptions{})
+
+	for {
+
+		obj, err := iter.Next(ctx)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list bucket, %w", err)
+		}
+
+		if obj.IsDir || !strings.HasSuffix(obj.Key, "-coloringbook.json") {
+			continue
+		}
+
+		m, err := ReadManifest(ctx, bucket, obj.Key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if m.Status == MANIFEST_STATUS_PENDING {
+			pending = append(pending, m)
+		}
+	}
+
+	sortManifests(pending)
+	return pending, nil
+}
+
+// Approve copies the sheets awaiting review for 'object_id' from the staging bucket to the public bucket defined
+// in 'opts' and marks them as approved. It returns the approved manifests, ordered by creation date.
+func Approve(ctx context.Context, opts *ReviewOptions, object_id int64) ([]*Manifest, error) {
+
+	pending, err := pendingManifests(ctx, opts, object_id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	staging_opts := stagingPublishOptions(opts.PublishOptions)
+
+	for key, m := range pending {
+
+		to_copy := map[string]string{
+			m.PDF:       opts.PublishOptions.PDFContentType,
+			m.Thumbnail: opts.PublishOptions.ThumbnailContentType,
+		}
+
+		for k, content_type := range to_copy {
+
+			err := copyFile(ctx, opts.StagingBucket, opts.Bucket, k, content_type, opts.PublishOptions, m.ObjectId, m.ImageId)
+
+			if err != nil {
+				return nil, err
+			}
+
+			log.Printf("Published %s\n", k)
+		}
+
+		m.Status = MANIFEST_STATUS_APPROVED
+		m.Reviewed = now
+
+		err := WriteManifest(ctx, opts.Bucket, key, opts.PublishOptions, m)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to write published manifest, %w", err)
+		}
+
+		err = WriteManifest(ctx, opts.StagingBucket, key, staging_opts, m)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to update staging manifest, %w", err)
+		}
+	}
+
+	return manifestValues(pending), nil
+}
+
+// Reject marks the sheets awaiting review for 'object_id' in the staging bucket defined in 'opts' as rejected.
+// It returns the rejected manifests, ordered by creation date.
+func Reject(ctx context.Context, opts *ReviewOptions, object_id int64) ([]*Manifest, error) {
+
+	pending, err := pendingManifests(ctx, opts, object_id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	staging_opts := stagingPublishOptions(opts.PublishOptions)
+
+	for key, m := range pending {
+
+		m.Status = MANIFEST_STATUS_REJECTED
+		m.Reviewed = now
+
+		err := WriteManifest(ctx, opts.StagingBucket, key, staging_opts, m)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to update staging manifest, %w", err)
+		}
+	}
+
+	return manifestValues(pending), nil
+}
+
+// stagingPublishOptions returns a copy of 'opts' without an ACL so that files updated in the (private) staging bucket
+// are not made public.
+func stagingPublishOptions(opts *PublishOptions) *PublishOptions {
+
+	if opts == nil {
+		return nil
+	}
+
+	staging_opts := *opts
+	staging_opts.ACL = ""
+
+	return &staging_opts
+}
+
+// pendingManifests returns the manifests awaiting review for 'object_id', keyed by their location in the staging bucket.
+func pendingManifests(ctx context.Context, opts *ReviewOptions, object_id int64) (map[string]*Manifest, error) {
+
+	keys, err := ListFiles(ctx, opts.StagingBucket, object_id, opts.AppendTree)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make(map[string]*Manifest)
+
+	for _, k := range keys {
+
+		if !strings.HasSuffix(k, ".json") {
+			continue
+		}
+
+		m, err := ReadManifest(ctx, opts.StagingBucket, k)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if m.Status == MANIFEST_STATUS_PENDING {
+			pending[k] = m
+		}
+	}
+
+	if len(pending) == 0 {
+		return nil, fmt.Errorf("No sheets awaiting review for object %d", object_id)
+	}
+
+	return pending, nil
+}
+
+func manifestValues(m map[string]*Manifest) []*Manifest {
+
+	values := make([]*Manifest, 0, len(m))
+
+	for _, v := range m {
+		values = append(values, v)
+	}
+
+	sortManifests(values)
+	return values
+}
+
+func sortManifests(manifests []*Manifest) {
+
+	sort.Slice(manifests, func(i, j int) bool {
+
+		if manifests[i].Created == manifests[j].Created {
+			return manifests[i].PDF < manifests[j].PDF
+		}
+
+		return manifests[i].Created < manifests[j].Created
+	})
+}
@@ -0,0 +1,394 @@
+package coloringbook
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+
+	"golang.org/x/image/tiff/lzw"
+)
+
+// The golang.org/x/image/tiff package doesn't support separated (CMYK) images, which are common for archival
+// master files, so they are decoded here. Only (stripped, chunky) 8- or 16-bit images which are uncompressed or
+// use LZW or Deflate compression, with or without a horizontal predictor, are supported.
+
+const (
+	tiff_tag_width             uint16 = 256
+	tiff_tag_height            uint16 = 257
+	tiff_tag_bits_per_sample   uint16 = 258
+	tiff_tag_compression       uint16 = 259
+	tiff_tag_photometric       uint16 = 262
+	tiff_tag_strip_offsets     uint16 = 273
+	tiff_tag_samples_per_pixel uint16 = 277
+	tiff_tag_rows_per_strip    uint16 = 278
+	tiff_tag_strip_byte_counts uint16 = 279
+	tiff_tag_planar_config     uint16 = 284
+	tiff_tag_predictor         uint16 = 317
+	tiff_tag_tile_width        uint16 = 322
+)
+
+const (
+	tiff_compression_none         uint = 1
+	tiff_compression_lzw          uint = 5
+	tiff_compression_deflate      uint = 8
+	tiff_compression_deflate_old  uint = 32946
+	tiff_photometric_separated    uint = 5
+	tiff_predictor_horizontal     uint = 2
+	tiff_planar_config_contiguous uint = 1
+)
+
+// cmykTIFF is the subset of a TIFF file's first IFD needed to decode a CMYK image.
+type cmykTIFF struct {
+	order           binary.ByteOrder
+	width           int
+	height          int
+	bits_per_sample int
+	samples         int
+	compression     uint
+	predictor       uint
+	rows_per_strip  int
+	strip_offsets   []uint
+	strip_counts    []uint
+}
+
+// isCMYKTIFF returns a boolean value indicating whether 'body' is a TIFF file whose first image is separated (CMYK).
+func isCMYKTIFF(body []byte) bool {
+
+	ifd, order, ok := tiffIFD(body)
+
+	if !ok {
+		return false
+	}
+
+	v, ok := ifd[tiff_tag_photometric]
+	return ok && len(v) == 1 && tiffValues(body, order, v[0])[0] == tiff_photometric_separated
+}
+
+// decodeCMYKTIFFConfig returns the dimensions and colour model of the CMYK TIFF file 'body'.
+func decodeCMYKTIFFConfig(body []byte) (image.Config, error) {
+
+	t, err := parseCMYKTIFF(body)
+
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	cfg := image.Config{
+		ColorModel: image.NewCMYK(image.Rect(0, 0, 1, 1)).ColorModel(),
+		Width:      t.width,
+		Height:     t.height,
+	}
+
+	return cfg, nil
+}
+
+// decodeCMYKTIFF decodes the CMYK TIFF file 'body'. 16-bit samples are reduced to 8 bits and any extra samples (for
+// example alpha) are ignored.
+func decodeCMYKTIFF(body []byte) (*image.CMYK, error) {
+
+	t, err := parseCMYKTIFF(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	bytes_per_sample := t.bits_per_sample / 8
+	row_size := t.width * t.samples * bytes_per_sample
+
+	pix := make([]byte, 0, row_size*t.height)
+
+	for i, offset := range t.strip_offsets {
+
+		count := t.strip_counts[i]
+
+		if offset+count > uint(len(body)) {
+			return nil, fmt.Errorf("Invalid TIFF strip %d, out of bounds", i)
+		}
+
+		strip, err := decompressTIFFStrip(body[offset:offset+count], t.compression)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decompress TIFF strip %d, %w", i, err)
+		}
+
+		// Each strip holds whole rows, the last of which may be partial
+
+		rows := t.rows_per_strip
+
+		if remaining := t.height - len(pix)/row_size; rows > remaining {
+			rows = remaining
+		}
+
+		if len(strip) < rows*row_size {
+			return nil, fmt.Errorf("Invalid TIFF strip %d, expected %d bytes but got %d", i, rows*row_size, len(strip))
+		}
+
+		pix = append(pix, strip[:rows*row_size]...)
+	}
+
+	if len(pix) < row_size*t.height {
+		return nil, fmt.Errorf("Invalid TIFF image, expected %d bytes but got %d", row_size*t.height, len(pix))
+	}
+
+	if t.predictor == tiff_predictor_horizontal {
+
+		for y := 0; y < t.height; y++ {
+			undoHorizontalPredictor(pix[y*row_size:(y+1)*row_size], t.samples, bytes_per_sample, t.order)
+		}
+	}
+
+	im := image.NewCMYK(image.Rect(0, 0, t.width, t.height))
+
+	for i := 0; i < t.width*t.height; i++ {
+
+		for c := 0; c < 4; c++ {
+
+			offset := (i*t.samples + c) * bytes_per_sample
+
+			if bytes_per_sample == 2 {
+				im.Pix[i*4+c] = uint8(t.order.Uint16(pix[offset:offset+2]) >> 8)
+			} else {
+				im.Pix[i*4+c] = pix[offset]
+			}
+		}
+	}
+
+	return im, nil
+}
+
+// parseCMYKTIFF reads the first IFD of the TIFF file 'body' and checks that it describes a CMYK image this package can decode.
+func parseCMYKTIFF(body []byte) (*cmykTIFF, error) {
+
+	ifd, order, ok := tiffIFD(body)
+
+	if !ok {
+		return nil, fmt.Errorf("Invalid TIFF file")
+	}
+
+	value := func(tag uint16, fallback uint) uint {
+
+		entry, ok := ifd[tag]
+
+		if !ok {
+			return fallback
+		}
+
+		return tiffValues(body, order, entry[0])[0]
+	}
+
+	if value(tiff_tag_photometric, 0) != tiff_photometric_separated {
+		return nil, fmt.Errorf("TIFF image is not a CMYK image")
+	}
+
+	if _, ok := ifd[tiff_tag_tile_width]; ok {
+		return nil, fmt.Errorf("Tiled CMYK TIFF images are not supported")
+	}
+
+	t := &cmykTIFF{
+		order:       order,
+		width:       int(value(tiff_tag_width, 0)),
+		height:      int(value(tiff_tag_height, 0)),
+		samples:     int(value(tiff_tag_samples_per_pixel, 4)),
+		compression: value(tiff_tag_compression, tiff_compression_none),
+		predictor:   value(tiff_tag_predictor, 1),
+	}
+
+	t.rows_per_strip = int(value(tiff_tag_rows_per_strip, uint(t.height)))
+
+	if t.width <= 0 || t.height <= 0 {
+		return nil, fmt.Errorf("Invalid TIFF image dimensions (%dx%d)", t.width, t.height)
+	}
+
+	if t.rows_per_strip <= 0 || t.rows_per_strip > t.height {
+		t.rows_per_strip = t.height
+	}
+
+	if t.samples < 4 {
+		return nil, fmt.Errorf("Invalid number of samples (%d) for a CMYK TIFF image", t.samples)
+	}
+
+	if value(tiff_tag_planar_config, tiff_planar_config_contiguous) != tiff_planar_config_contiguous {
+		return nil, fmt.Errorf("Planar CMYK TIFF images are not supported")
+	}
+
+	bits, ok := ifd[tiff_tag_bits_per_sample]
+
+	if !ok {
+		return nil, fmt.Errorf("TIFF image is missing bits per sample")
+	}
+
+	for _, b := range tiffValues(body, order, bits[0]) {
+
+		if b != 8 && b != 16 {
+			return nil, fmt.Errorf("Unsupported bits per sample (%d) for a CMYK TIFF image", b)
+		}
+
+		t.bits_per_sample = int(b)
+	}
+
+	switch t.compression {
+	case tiff_compression_none, tiff_compression_lzw, tiff_compression_deflate, tiff_compression_deflate_old:
+		// pass
+	default:
+		return nil, fmt.Errorf("Unsupported compression (%d) for a CMYK TIFF image", t.compression)
+	}
+
+	offsets, ok_offsets := ifd[tiff_tag_strip_offsets]
+	counts, ok_counts := ifd[tiff_tag_strip_byte_counts]
+
+	if !ok_offsets || !ok_counts {
+		return nil, fmt.Errorf("TIFF image is missing strip offsets or byte counts")
+	}
+
+	t.strip_offsets = tiffValues(body, order, offsets[0])
+	t.strip_counts = tiffValues(body, order, counts[0])
+
+	if len(t.strip_offsets) == 0 || len(t.strip_offsets) != len(t.strip_counts) {
+		return nil, fmt.Errorf("Invalid TIFF strip offsets or byte counts")
+	}
+
+	return t, nil
+}
+
+// tiffIFD returns the raw (12-byte) entries of the first IFD in the TIFF file 'body', keyed by tag, and its byte order.
+func tiffIFD(body []byte) (map[uint16][][]byte, binary.ByteOrder, bool) {
+
+	if len(body) < 8 {
+		return nil, nil, false
+	}
+
+	var order binary.ByteOrder
+
+	switch string(body[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, nil, false
+	}
+
+	if order.Uint16(body[2:4]) != 42 {
+		return nil, nil, false
+	}
+
+	offset := int(order.Uint32(body[4:8]))
+
+	if offset < 8 || offset+2 > len(body) {
+		return nil, nil, false
+	}
+
+	count := int(order.Uint16(body[offset : offset+2]))
+
+	if offset+2+count*12 > len(body) {
+		return nil, nil, false
+	}
+
+	ifd := make(map[uint16][][]byte)
+
+	for i := 0; i < count; i++ {
+
+		entry := body[offset+2+i*12 : offset+2+(i+1)*12]
+		tag := order.Uint16(entry[0:2])
+
+		ifd[tag] = append(ifd[tag], entry)
+	}
+
+	return ifd, order, true
+}
+
+// tiffValues returns the (SHORT or LONG) values of the IFD entry 'entry'. It returns a single 0 value if the entry is invalid.
+func tiffValues(body []byte, order binary.ByteOrder, entry []byte) []uint {
+
+	size := 0
+
+	switch order.Uint16(entry[2:4]) {
+	case 3:
+		size = 2
+	case 4:
+		size = 4
+	default:
+		return []uint{0}
+	}
+
+	count := int(order.Uint32(entry[4:8]))
+
+	if count == 0 {
+		return []uint{0}
+	}
+
+	data := entry[8:12]
+
+	if count*size > 4 {
+
+		offset := int(order.Uint32(entry[8:12]))
+
+		if offset < 0 || offset+count*size > len(body) {
+			return []uint{0}
+		}
+
+		data = body[offset : offset+count*size]
+	}
+
+	values := make([]uint, count)
+
+	for i := range values {
+
+		if size == 2 {
+			values[i] = uint(order.Uint16(data[i*2 : i*2+2]))
+		} else {
+			values[i] = uint(order.Uint32(data[i*4 : i*4+4]))
+		}
+	}
+
+	return values
+}
+
+// decompressTIFFStrip returns the decompressed contents of the strip 'data'.
+func decompressTIFFStrip(data []byte, compression uint) ([]byte, error) {
+
+	var r io.ReadCloser
+
+	switch compression {
+	case tiff_compression_none:
+		return data, nil
+	case tiff_compression_lzw:
+		r = lzw.NewReader(bytes.NewReader(data), lzw.MSB, 8)
+	case tiff_compression_deflate, tiff_compression_deflate_old:
+
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+
+		if err != nil {
+			return nil, err
+		}
+
+		r = zr
+	default:
+		return nil, fmt.Errorf("Unsupported compression (%d)", compression)
+	}
+
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// undoHorizontalPredictor reverses, in place, the horizontal differencing applied to the samples in 'row'.
+func undoHorizontalPredictor(row []byte, samples int, bytes_per_sample int, order binary.ByteOrder) {
+
+	if bytes_per_sample == 2 {
+
+		for i := samples * 2; i+2 <= len(row); i += 2 {
+			v := order.Uint16(row[i:i+2]) + order.Uint16(row[i-samples*2:i-samples*2+2])
+			order.PutUint16(row[i:i+2], v)
+		}
+
+		return
+	}
+
+	for i := samples; i < len(row); i++ {
+		row[i] += row[i-samples]
+	}
+}
@@ -0,0 +1,86 @@
+package coloringbook
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// FindVtracer returns the path to the vtracer binary or an error if it can not be found in the current PATH.
+func FindVtracer() (string, error) {
+
+	path, err := exec.LookPath("vtracer")
+
+	if err != nil {
+		return "", fmt.Errorf("vtracer binary not found in PATH, %w", err)
+	}
+
+	return path, nil
+}
+
+// FindJava returns the path to the java binary or an error if it can not be found in the current PATH.
+func FindJava() (string, error) {
+
+	path, err := exec.LookPath("java")
+
+	if err != nil {
+		return "", fmt.Errorf("java binary not found in PATH, %w", err)
+	}
+
+	return path, nil
+}
+
+// FindBatik returns an error if the Batik rasterizer JAR file 'path_batik', or the java binary used to
+// run it, can not be found.
+func FindBatik(path_batik string) error {
+
+	_, err := FindJava()
+
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(path_batik)
+
+	if err != nil {
+		return fmt.Errorf("Batik rasterizer not found at %s, %w", path_batik, err)
+	}
+
+	if info.IsDir() {
+		return fmt.Errorf("Batik rasterizer path %s is a directory", path_batik)
+	}
+
+	return nil
+}
+
+// VtracerVersion returns the version string reported by the vtracer binary.
+func VtracerVersion(ctx context.Context) (string, error) {
+
+	out, err := exec.CommandContext(ctx, "vtracer", "--version").CombinedOutput()
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to determine vtracer version, %w", err)
+	}
+
+	return firstLine(out), nil
+}
+
+// JavaVersion returns the version string reported by the java binary.
+func JavaVersion(ctx context.Context) (string, error) {
+
+	// java writes its version to STDERR
+	out, err := exec.CommandContext(ctx, "java", "-version").CombinedOutput()
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to determine java version, %w", err)
+	}
+
+	return firstLine(out), nil
+}
+
+func firstLine(out []byte) string {
+	lines := strings.SplitN(strings.TrimSpace(string(out)), "\n", 2)
+	return strings.TrimSpace(lines[0])
+}
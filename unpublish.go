@@ -0,0 +1,299 @@
+package coloringbook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/whosonfirst/go-reader"
+	wof_reader "github.com/whosonfirst/go-whosonfirst-reader"
+	"gocloud.dev/blob"
+)
+
+type UnpublishOptions struct {
+	// The bucket where coloring book sheets are published.
+	Bucket *blob.Bucket
+	// An optional reader used to load object records. If not nil the files listed in an object's "millsfield:coloring_book"
+	// property are removed, as well as the files found using ListFiles, which accounts for sheets published with a custom
+	// filename. If the record can not be read only the files found using ListFiles are removed.
+	Reader reader.Reader
+	// A boolean flag indicating whether files were published using a Who's On First -style tree.
+	AppendTree bool
+	// A boolean flag indicating that files should be listed but not removed.
+	DryRun bool
+	// If greater than 0 only the files derived from this image ID (as parsed by ParseFilename) are removed.
+	ImageId int64
+}
+
+// Unpublish removes all the files (PDF sheets, thumbnails and any sidecar files) published for 'object_id'
+// from the bucket defined in 'opts'. It returns the list of keys that were removed.
+func Unpublish(ctx context.Context, opts *UnpublishOptions, object_id int64) ([]string, error) {
+
+	keys := make([]string, 0)
+	seen := make(map[string]bool)
+
+	add_keys := func(new_keys []string) {
+
+		for _, k := range new_keys {
+
+			if seen[k] {
+				continue
+			}
+
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+
+	if opts.Reader != nil {
+
+		body, err := wof_reader.LoadBytes(ctx, opts.Reader, object_id)
+
+		if err != nil {
+			log.Printf("Failed to load record for object %d, listing files by prefix instead, %v\n", object_id, err)
+		} else {
+
+			record_keys, err := ColoringBookFiles(ctx, opts.Bucket, body)
+
+			if err != nil {
+				return nil, err
+			}
+
+			add_keys(record_keys)
+		}
+	}
+
+	// The record may not list every file, for example sheets published before it was last updated,
+	// so files matching the default filename prefix are always removed too.
+
+	prefix_keys, err := ListFiles(ctx, opts.Bucket, object_id, opts.AppendTree)
+
+	if err != nil {
+		return nil, err
+	}
+
+	add_keys(prefix_keys)
+
+	if opts.ImageId > 0 {
+		keys = filterImageFiles(keys, opts.ImageId)
+	}
+
+	removed := make([]string, 0)
+
+	for _, k := range keys {
+
+		if opts.DryRun {
+			log.Printf("[dryrun] Remove %s\n", k)
+			removed = append(removed, k)
+			continue
+		}
+
+		err := opts.Bucket.Delete(ctx, k)
+
+		if err != nil {
+			return removed, fmt.Errorf("Failed to remove %s, %w", k, err)
+		}
+
+		log.Printf("Removed %s\n", k)
+		removed = append(removed, k)
+	}
+
+	return removed, nil
+}
+
+// ListFiles returns the keys of all the files (PDF sheets, thumbnails and any sidecar files) published
+// for 'object_id' in 'bucket'. If 'append_tree' is true files are assumed to have been published using
+// a Who's On First -style tree.
+func ListFiles(ctx context.Context, bucket *blob.Bucket, object_id int64, append_tree bool) ([]string, error) {
+
+	prefix := FilenamePrefix(object_id)
+
+	if append_tree {
+
+		p, err := AppendTree(object_id, prefix)
+
+		if err != nil {
+			return nil, err
+		}
+
+		prefix = p
+	}
+
+	list_opts := &blob.ListOptions{
+		Prefix: prefix,
+	}
+
+	keys := make([]string, 0)
+
+	iter := bucket.List(list_opts)
+
+	for {
+
+		obj, err := iter.Next(ctx)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list files with prefix %s, %w", prefix, err)
+		}
+
+		if obj.IsDir {
+			continue
+		}
+
+		// Account for the bucket being a directory with other, unrelated files that happen
+		// to start with the object ID.
+
+		if !strings.Contains(obj.Key, "-coloringbook") {
+			continue
+		}
+
+		keys = append(keys, obj.Key)
+	}
+
+	return keys, nil
+}
+
+// filterImageFiles returns the keys in 'keys' whose filename (as parsed by ParseFilename) encodes 'image_id'.
+func filterImageFiles(keys []string, image_id int64) []string {
+
+	filtered := make([]string, 0)
+
+	for _, k := range keys {
+
+		_, k_id, _, err := ParseFilename(k)
+
+		if err != nil || k_id != image_id {
+			continue
+		}
+
+		filtered = append(filtered, k)
+	}
+
+	return filtered
+}
+
+// ColoringBookFiles returns the keys in 'bucket' of the PDF sheets and thumbnail images listed in the "millsfield:coloring_book"
+// property (including any difficulty variants) of the object record 'body', and the manifests published alongside them.
+// Files which don't exist in 'bucket' are ignored.
+func ColoringBookFiles(ctx context.Context, bucket *blob.Bucket, body []byte) ([]string, error) {
+
+	keys := make([]string, 0)
+
+	prop_rsp := gjson.GetBytes(body, COLORING_BOOK_PROPERTY)
+
+	if !prop_rsp.Exists() {
+		return keys, nil
+	}
+
+	var prop *ColoringBookProperty
+
+	err := json.Unmarshal([]byte(prop_rsp.Raw), &prop)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal coloring book property, %w", err)
+	}
+
+	if prop == nil {
+		return keys, nil
+	}
+
+	props := append([]*ColoringBookProperty{prop}, prop.Variants...)
+	seen := make(map[string]bool)
+
+	add_key := func(k string) {
+
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+
+	for _, p := range props {
+
+		if p == nil {
+			continue
+		}
+
+		for _, file_uri := range []string{p.PDF, p.Thumbnail} {
+
+			if file_uri == "" {
+				continue
+			}
+
+			k, err := resolvePropertyKey(ctx, bucket, file_uri)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if k == "" {
+				continue
+			}
+
+			add_key(k)
+
+			if !strings.HasSuffix(k, ".pdf") {
+				continue
+			}
+
+			manifest_key := ManifestFilename(k)
+
+			exists, err := bucket.Exists(ctx, manifest_key)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to determine whether %s exists, %w", manifest_key, err)
+			}
+
+			if exists {
+				add_key(manifest_key)
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// resolvePropertyKey returns the key in 'bucket' of the file referenced by 'file_uri', a (public) URI or bucket key
+// taken from a "millsfield:coloring_book" property. Since the public root URI prepended to keys is not known, leading
+// path segments are removed until a key that exists in 'bucket' is found. It returns an empty string if there is no match.
+func resolvePropertyKey(ctx context.Context, bucket *blob.Bucket, file_uri string) (string, error) {
+
+	path := file_uri
+
+	u, err := url.Parse(file_uri)
+
+	if err == nil && u.Scheme != "" {
+		path = u.Path
+	}
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	for i := range segments {
+
+		k := strings.Join(segments[i:], "/")
+
+		if k == "" {
+			continue
+		}
+
+		exists, err := bucket.Exists(ctx, k)
+
+		if err != nil {
+			return "", fmt.Errorf("Failed to determine whether %s exists, %w", k, err)
+		}
+
+		if exists {
+			return k, nil
+		}
+	}
+
+	return "", nil
+}